@@ -0,0 +1,47 @@
+package dnssd
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Find the published records that should be sent in the additional
+// section with an answer, RFC6763 section 12. A PTR brings the SRV and
+// TXT of the service instance, an SRV brings the addresses of its target
+// and an address brings the addresses of the other family.
+func (ds *dnssd) additionalAnswers(ifIndex int, rr dns.RR) []*answer {
+	var additionals []*answer
+	add := func(name string, rrtype uint16) {
+		q := &dns.Question{Name: name, Qtype: rrtype, Qclass: dns.ClassINET}
+		for _, a := range ds.rrl.matchQuestionOnInterface(ifIndex, q) {
+			additionals = appendAnswerIfMissing(additionals, a)
+		}
+	}
+	switch rr := rr.(type) {
+	case *dns.PTR:
+		add(rr.Ptr, dns.TypeSRV)
+		add(rr.Ptr, dns.TypeTXT)
+		for _, a := range additionals {
+			if srv, ok := a.rr.(*dns.SRV); ok {
+				add(srv.Target, dns.TypeA)
+				add(srv.Target, dns.TypeAAAA)
+			}
+		}
+	case *dns.SRV:
+		add(rr.Target, dns.TypeA)
+		add(rr.Target, dns.TypeAAAA)
+	case *dns.A:
+		add(rr.Hdr.Name, dns.TypeAAAA)
+	case *dns.AAAA:
+		add(rr.Hdr.Name, dns.TypeA)
+	}
+	return additionals
+}
+
+func appendAnswerIfMissing(as []*answer, a *answer) []*answer {
+	for _, ta := range as {
+		if ta == a {
+			return as
+		}
+	}
+	return append(as, a)
+}
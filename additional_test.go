@@ -0,0 +1,92 @@
+package dnssd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func (ds *dnssd) addTestService(ifIndex int) {
+	fakeRRs := fakeIncomingMsg(true).
+		addRR("_tuting._tcp.local.", dns.TypePTR, "Stryfnake._tuting._tcp.local.").
+		addRR("Stryfnake._tuting._tcp.local.", dns.TypeSRV, 0, 0, 4711, "myhost.local.").
+		addRR("Stryfnake._tuting._tcp.local.", dns.TypeTXT, "test=hej").
+		addRR("myhost.local.", dns.TypeA, "10.0.0.1").
+		addRR("otherhost.local.", dns.TypeA, "10.0.0.2")
+	for _, rr := range fakeRRs.msg.Answer {
+		ds.rrl.addRecord(nil, Shared, ifIndex, rr)
+	}
+}
+
+func TestAdditionalAnswers(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+
+	ptr := ds.rrl.cache[0].rr
+	additionals := ds.additionalAnswers(2, ptr)
+	assert.Equal(t, 3, len(additionals))
+	assert.Equal(t, dns.TypeSRV, additionals[0].rr.Header().Rrtype)
+	assert.Equal(t, dns.TypeTXT, additionals[1].rr.Header().Rrtype)
+	assert.Equal(t, "myhost.local.", additionals[2].rr.Header().Name)
+
+	srv := ds.rrl.cache[1].rr
+	additionals = ds.additionalAnswers(2, srv)
+	assert.Equal(t, 1, len(additionals))
+	assert.Equal(t, dns.TypeA, additionals[0].rr.Header().Rrtype)
+
+	// Not published on the interface.
+	assert.Equal(t, 0, len(ds.additionalAnswers(3, ptr)))
+}
+
+func TestHandleQueryAdditionals(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+
+	im := fakeIncomingMsg(false).addRR("Stryfnake._tuting._tcp.local.", dns.TypeTXT, "test=hej")
+	im.msg.Question = []dns.Question{{Name: "_tuting._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+
+	assert.Equal(t, 1, len(ds.ns.responses))
+	// The TXT record is known by the querier.
+	assert.Equal(t, 2, len(ds.ns.additionals))
+	assert.Equal(t, dns.TypeSRV, ds.ns.additionals[0].rr.Header().Rrtype)
+	assert.Equal(t, dns.TypeA, ds.ns.additionals[1].rr.Header().Rrtype)
+	assert.Equal(t, ds.ns.responses[0].at, ds.ns.additionals[0].at)
+}
+
+func TestHandleQueryAdditionalsRateLimited(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+
+	// The answer was multicast half a second ago and is delayed, its
+	// additional records are sent with it.
+	last := time.Now().Add(-500 * time.Millisecond)
+	ds.rrl.cache[0].multicastAt(2, last)
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{{Name: "_tuting._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.False(t, ds.ns.responses[0].at.Before(last.Add(time.Second)))
+	assert.Equal(t, 3, len(ds.ns.additionals))
+	for _, pr := range ds.ns.additionals {
+		assert.Equal(t, ds.ns.responses[0].at, pr.at)
+	}
+}
+
+func TestAdditionalRecords(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	to := fakeIncomingMsg(false).from
+	a := makeTestARecord("myhost.local.", "10.0.0.1")
+	ns.sendAdditionalRecord(2, a, Shared, nil, time.Now())
+	ns.sendAdditionalRecord(3, a, Shared, nil, time.Now())
+	ns.sendAdditionalRecord(2, a, Unique, to, time.Now())
+
+	assert.Equal(t, []dns.RR{a}, additionalRecords(ns.additionals, 2, nil))
+	assert.Equal(t, 0, len(additionalRecords(ns.additionals, 4, nil)))
+	rrs := additionalRecords(ns.additionals, 2, to)
+	assert.Equal(t, 1, len(rrs))
+	assert.Equal(t, uint16(dns.ClassINET|cacheFlushBit), rrs[0].Header().Class)
+}
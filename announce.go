@@ -0,0 +1,114 @@
+package dnssd
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	announceLock     sync.Mutex
+	announceCount    = 2
+	announceInterval = time.Second
+)
+
+/*
+SetAnnouncements configures how published records are announced, RFC6762 section 8.3.
+count is the number of announcements, at least two and at most eight. interval is the time between
+the first and second announcement, at least one second, and is doubled for each following
+announcement. The default is two announcements one second apart. The schedule applies to
+records published, or updated, after the call.
+*/
+func SetAnnouncements(count int, interval time.Duration) {
+	if count < 2 {
+		count = 2
+	}
+	if count > 8 {
+		count = 8
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	announceLock.Lock()
+	defer announceLock.Unlock()
+	announceCount = count
+	announceInterval = interval
+}
+
+func getAnnouncements() (int, time.Duration) {
+	announceLock.Lock()
+	defer announceLock.Unlock()
+	return announceCount, announceInterval
+}
+
+// The announcements left to send for a published record.
+type announcement struct {
+	a        *answer
+	count    int
+	interval time.Duration
+	next     time.Time
+}
+
+// Delay of announcements so records published together are sent together.
+const announceDelay = 10 * time.Millisecond
+
+// Start announcing a published record. The first announcement is sent
+// right away. A running announcement of the record is started over,
+// e.g. when the record data has been updated.
+func (ds *dnssd) startAnnouncement(a *answer) {
+	count, interval := getAnnouncements()
+	an := &announcement{a, count, interval, time.Time{}}
+	ds.announce(an, time.Now())
+	ds.nextCheckAt(time.Until(an.next))
+	for ii, oan := range ds.as {
+		if oan.a == a {
+			ds.as[ii] = an
+			return
+		}
+	}
+	ds.as = append(ds.as, an)
+}
+
+// Send an announcement and schedule the next one.
+func (ds *dnssd) announce(an *announcement, now time.Time) {
+	dnssdlog.Debug.Println("ANNOUNCE=", an.a.rr, ", left=", an.count)
+	at := ds.nextSendAt(announceDelay)
+	an.a.multicastAt(an.a.ifIndex, at)
+	ds.ns.sendResponseRecord(an.a.ifIndex, an.a.rr, an.a.flags, at)
+	an.count--
+	an.next = now.Add(an.interval)
+	an.interval *= 2
+}
+
+// Stop announcing a record that has been withdrawn.
+func (ds *dnssd) stopAnnouncement(a *answer) {
+	jj := 0
+	for _, an := range ds.as {
+		if an.a != a {
+			ds.as[jj] = an
+			jj++
+		}
+	}
+	ds.as = ds.as[0:jj]
+}
+
+// Send the announcements that are due and return the time of the next one.
+func (ds *dnssd) runAnnouncements() time.Time {
+	now := time.Now()
+	var next time.Time
+	jj := 0
+	for _, an := range ds.as {
+		if an.a.isClosed() {
+			continue
+		}
+		if !an.next.After(now) {
+			ds.announce(an, now)
+		}
+		if an.count > 0 {
+			next = getNextTime(next, an.next)
+			ds.as[jj] = an
+			jj++
+		}
+	}
+	ds.as = ds.as[0:jj]
+	return next
+}
@@ -0,0 +1,103 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetAnnouncements(t *testing.T) {
+	defer SetAnnouncements(2, time.Second)
+
+	SetAnnouncements(1, 2*time.Second)
+	count, interval := getAnnouncements()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2*time.Second, interval)
+
+	SetAnnouncements(12, time.Second)
+	count, _ = getAnnouncements()
+	assert.Equal(t, 8, count)
+
+	SetAnnouncements(2, 0)
+	_, interval = getAnnouncements()
+	assert.Equal(t, time.Second, interval)
+}
+
+func TestAnnouncementSchedule(t *testing.T) {
+	defer SetAnnouncements(2, time.Second)
+	SetAnnouncements(3, time.Second)
+
+	ds, _ := makeTestDnssd(t)
+	rr := makeTestARecord("myhost.local.", "10.0.0.1")
+	a, _ := ds.rrl.addRecord(context.Background(), Unique, 2, rr)
+
+	ds.startAnnouncement(a)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, 1, len(ds.as))
+	assert.Equal(t, 2, ds.as[0].count)
+	assert.Equal(t, 2*time.Second, ds.as[0].interval)
+	assert.False(t, a.lastMulticastOn(2).IsZero())
+
+	// Not due yet.
+	next := ds.runAnnouncements()
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, ds.as[0].next, next)
+
+	// Second announcement, interval doubled for the third.
+	ds.ns.responses = nil
+	ds.as[0].next = time.Now()
+	ds.runAnnouncements()
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, 4*time.Second, ds.as[0].interval)
+
+	// Last announcement, nothing more to send.
+	ds.ns.responses = nil
+	ds.as[0].next = time.Now()
+	next = ds.runAnnouncements()
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, 0, len(ds.as))
+	assert.True(t, next.IsZero())
+}
+
+func TestAnnouncementRestart(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	rr := makeTestARecord("myhost.local.", "10.0.0.1")
+	a, _ := ds.rrl.addRecord(context.Background(), Unique, 2, rr)
+
+	ds.startAnnouncement(a)
+	ds.startAnnouncement(a)
+	assert.Equal(t, 1, len(ds.as))
+	assert.Equal(t, 1, ds.as[0].count)
+
+	ds.stopAnnouncement(a)
+	assert.Equal(t, 0, len(ds.as))
+}
+
+func TestAnnouncementClosed(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := makeTestARecord("myhost.local.", "10.0.0.1")
+	a, _ := ds.rrl.addRecord(ctx, Unique, 2, rr)
+
+	ds.startAnnouncement(a)
+	cancel()
+	ds.as[0].next = time.Now()
+	ds.runAnnouncements()
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, 0, len(ds.as))
+}
+
+func TestPublishUniqueRRSet(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ll := makeAddressRecord("h.local.", net.ParseIP("fe80::1"))
+	global := makeAddressRecord("h.local.", net.ParseIP("2001:db8::1"))
+
+	// A host may have several addresses on an interface.
+	ds.publish(context.Background(), Unique, 2, ll, nil)
+	ds.publish(context.Background(), Unique, 2, global, nil)
+	assert.Equal(t, 2, ds.rrl.size())
+	assert.Equal(t, 2, len(ds.as))
+}
@@ -21,10 +21,13 @@ type answer struct {
 	requeried int
 	ifIndex   int
 	rr        dns.RR
+	conflict  func(rr dns.RR) // Called when a published unique record is challenged.
+
+	lastMulticast map[int]time.Time // When a published record was last multicast on each interface.
 }
 
 func matchAnswers(a1, a2 *answer) bool {
-	return a1.ifIndex == a2.ifIndex && matchRRs(a1.rr, a2.rr)
+	return a1.ifIndex == a2.ifIndex && matchRRData(a1.rr, a2.rr)
 }
 
 func makeAnswers() *answers {
@@ -36,7 +39,7 @@ func (aa *answers) addRecord(ctx context.Context, flags Flags, ifIndex int, rr d
 	if ttl > 0 {
 		ttl += randomDuration(ttl, 2)
 	}
-	a := &answer{ctx, time.Now(), ttl, flags, 0, ifIndex, rr}
+	a := &answer{ctx, time.Now(), ttl, flags, 0, ifIndex, rr, nil, nil}
 	return a, aa.add(a)
 }
 
@@ -54,6 +57,18 @@ func (aa *answers) add(a *answer) bool {
 	return true
 }
 
+// Remove the answer for a record on an interface.
+func (aa *answers) removeRecord(ifIndex int, rr dns.RR) {
+	ii := 0
+	for _, a := range aa.cache {
+		if a.ifIndex != ifIndex || !matchRRs(a.rr, rr) {
+			aa.cache[ii] = a
+			ii++
+		}
+	}
+	aa.cache = aa.cache[0:ii]
+}
+
 func (aa *answers) size() int {
 	return len(aa.cache)
 }
@@ -76,6 +91,18 @@ func (aa *answers) matchQuestion(q *dns.Question) []*answer {
 	return matchedAnswers
 }
 
+// Match a question received on an interface. Only answers published
+// on all interfaces, or on the interface itself, match.
+func (aa *answers) matchQuestionOnInterface(ifIndex int, q *dns.Question) []*answer {
+	var matchedAnswers []*answer
+	for _, a := range aa.matchQuestion(q) {
+		if a.ifIndex == 0 || a.ifIndex == ifIndex {
+			matchedAnswers = append(matchedAnswers, a)
+		}
+	}
+	return matchedAnswers
+}
+
 func (aa *answers) findAnswerFromRR(rr dns.RR) (*answer, bool) {
 	for _, a := range aa.cache {
 		if matchRRHeader(rr.Header(), a.rr.Header()) {
@@ -85,6 +112,42 @@ func (aa *answers) findAnswerFromRR(rr dns.RR) (*answer, bool) {
 	return nil, false
 }
 
+// Find the answer for a record on an interface regardless of TTL.
+func (aa *answers) findAnswer(ifIndex int, rr dns.RR) *answer {
+	for _, a := range aa.cache {
+		if a.ifIndex == ifIndex && matchRRData(a.rr, rr) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Call f for all answers in the same rrset, same name, type and class,
+// as the record on an interface.
+func (aa *answers) iterateRRSet(ifIndex int, rr dns.RR, f func(a *answer)) {
+	for _, a := range aa.cache {
+		if a.ifIndex == ifIndex && matchRRHeader(rr.Header(), a.rr.Header()) {
+			f(a)
+		}
+	}
+}
+
+// Find a unique answer conflicting with a received record. The record
+// is in conflict if we have unique records with the same name, type and
+// class but none of them has identical rdata.
+func (aa *answers) findConflict(rr dns.RR) *answer {
+	var conflict *answer
+	for _, a := range aa.cache {
+		if a.flags&Unique != 0 && matchRRHeader(rr.Header(), a.rr.Header()) {
+			if matchRRData(rr, a.rr) {
+				return nil
+			}
+			conflict = a
+		}
+	}
+	return conflict
+}
+
 func (a *answer) String() string {
 	s := ""
 	if a.flags&Shared != 0 {
@@ -133,6 +196,77 @@ func (aa *answers) findOldAnswers(requery func(a *answer), remove func(a *answer
 	return nextTime
 }
 
+// The TTL in seconds left before the answer expires.
+func (a *answer) remainingTTL() uint32 {
+	left := a.ttl - time.Since(a.added)
+	if left <= 0 {
+		return 0
+	}
+	ttl := uint32(left / time.Second)
+	if ttl > a.rr.Header().Ttl {
+		return a.rr.Header().Ttl
+	}
+	return ttl
+}
+
+// Check if a published record was multicast on an interface within the
+// last quarter of its TTL. A QU question may then be answered with
+// unicast only.
+func (a *answer) multicastRecently(ifIndex int) bool {
+	last := a.lastMulticastOn(ifIndex)
+	if last.IsZero() {
+		return false
+	}
+	quarter := time.Duration(a.rr.Header().Ttl) * time.Second / 4
+	return time.Since(last) < quarter
+}
+
+// The last time, possibly scheduled in the future, the record was
+// multicast on an interface. Multicasts on all interfaces count.
+func (a *answer) lastMulticastOn(ifIndex int) time.Time {
+	last := a.lastMulticast[ifIndex]
+	if all := a.lastMulticast[0]; all.After(last) {
+		last = all
+	}
+	return last
+}
+
+// Remember when the record is multicast on an interface.
+func (a *answer) multicastAt(ifIndex int, at time.Time) {
+	if a.lastMulticast == nil {
+		a.lastMulticast = make(map[int]time.Time)
+	}
+	a.lastMulticast[ifIndex] = at
+}
+
+// Check if a peer already knows our answer. A known answer only
+// suppresses our response if the peer has at least half of our TTL
+// left, RFC6762 section 7.1.
+func (a *answer) isKnownAnswer(known []dns.RR) bool {
+	for _, kr := range known {
+		if matchRRDataIgnoreFlush(a.rr, kr) && kr.Header().Ttl*2 >= a.rr.Header().Ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the answer to expire after d without any further requeries. Used
+// when a goodbye has been received, RFC6762 section 10.1.
+func (a *answer) expireIn(d time.Duration) {
+	a.added = time.Now()
+	a.ttl = d
+	a.requeried = a.maxRequeries()
+}
+
+// The number of requeries sent before a record expires.
+func (a *answer) maxRequeries() int {
+	if a.flags&Unique != 0 {
+		return 1
+	}
+	return 4
+}
+
 func (a *answer) getNextCheckTime() (time.Time, bool) {
 	rt := a.added
 	if a.flags&Unique != 0 {
@@ -144,7 +278,7 @@ func (a *answer) getNextCheckTime() (time.Time, bool) {
 			rt = rt.Add(a.ttl)
 
 		}
-		return rt, a.requeried < 1
+		return rt, a.requeried < a.maxRequeries()
 	} else {
 		switch a.requeried {
 		case 0: // At 80% of TTL
@@ -159,7 +293,7 @@ func (a *answer) getNextCheckTime() (time.Time, bool) {
 			rt = rt.Add(a.ttl)
 
 		}
-		return rt, a.requeried < 4
+		return rt, a.requeried < a.maxRequeries()
 	}
 }
 
@@ -13,11 +13,11 @@ func makeTestPtrAnswer(ifIndex int, name, ptr string, ttl uint32) *answer {
 	ptr1 := new(dns.PTR)
 	ptr1.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl} // TODO: TTL correct?
 	ptr1.Ptr = ptr
-	return &answer{nil, time.Now(), time.Duration(ttl) * time.Second, Shared, 0, ifIndex, ptr1}
+	return &answer{nil, time.Now(), time.Duration(ttl) * time.Second, Shared, 0, ifIndex, ptr1, nil, nil}
 }
 
 func makeTestPtrQuestion(name string) *question {
-	return &question{&dns.Question{Name: name, Qclass: dns.ClassINET, Qtype: dns.TypePTR}, nil}
+	return &question{q: &dns.Question{Name: name, Qclass: dns.ClassINET, Qtype: dns.TypePTR}}
 }
 
 func (aa *answers) dump(ref string) {
@@ -67,6 +67,19 @@ func TestMatchQuestion(t *testing.T) {
 	assert.Equal(t, 2, len(aa.matchQuestion(q)))
 }
 
+func TestMatchQuestionOnInterface(t *testing.T) {
+	aa := makeAnswers()
+	q := &dns.Question{Name: "hi_there", Qclass: dns.ClassINET, Qtype: dns.TypePTR}
+	aa.add(makeTestPtrAnswer(0, "hi_there", "everywhere", 3200))
+	aa.add(makeTestPtrAnswer(2, "hi_there", "two", 3200))
+	aa.add(makeTestPtrAnswer(3, "hi_there", "three", 3200))
+
+	assert.Equal(t, 2, len(aa.matchQuestionOnInterface(2, q)))
+	assert.Equal(t, "two", aa.matchQuestionOnInterface(2, q)[1].rr.(*dns.PTR).Ptr)
+	assert.Equal(t, "three", aa.matchQuestionOnInterface(3, q)[1].rr.(*dns.PTR).Ptr)
+	assert.Equal(t, 1, len(aa.matchQuestionOnInterface(4, q)))
+}
+
 func TestFindAnswerFromRR(t *testing.T) {
 	aa := makeAnswers()
 
@@ -80,6 +93,20 @@ func TestFindAnswerFromRR(t *testing.T) {
 
 }
 
+func TestFindConflict(t *testing.T) {
+	aa := makeAnswers()
+
+	a1 := makeTestPtrAnswer(2, "hi_there", "wazzup", 3200)
+	aa.add(a1)
+	assert.Nil(t, aa.findConflict(makeTestPtrAnswer(2, "hi_there", "yowza", 3200).rr))
+
+	// Only unique records can be in conflict
+	a1.flags = Unique
+	assert.Equal(t, a1, aa.findConflict(makeTestPtrAnswer(2, "hi_there", "yowza", 3200).rr))
+	assert.Nil(t, aa.findConflict(makeTestPtrAnswer(2, "hi_there", "wazzup", 120).rr))
+	assert.Nil(t, aa.findConflict(makeTestPtrAnswer(2, "yo", "yowza", 3200).rr))
+}
+
 func TestAnswerString(t *testing.T) {
 	now := time.Now()
 
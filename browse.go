@@ -20,7 +20,10 @@ domain The domain the service was discovered on.
 type ServiceUpdate func(found bool, flags Flags, ifIndex int, serviceName, regType, domain string)
 
 /*
-Browse a service. ctx is the context used to cancel a browse. flags are currently unused. ifIndex is
+Browse a service. The response closure is called with found set to false when a service
+has sent a goodbye or its PTR record has expired. flags are set to dnssd.MoreComing when more
+updates from the same packet follow immediately.
+ctx is the context used to cancel a browse. flags are currently unused. ifIndex is
 used to indicate which interface the service should be browsed on. regType is the service type (e g _http._tcp)
 domain is the domain to browse for the service. If domain is set blank the default domain will be used. response
 is a closure called when service data has been updated. errc is called when an error has occured.
@@ -31,9 +34,13 @@ func Browse(ctx context.Context, flags Flags, ifIndex int, regType, domain strin
 	question := &dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET}
 	query(ctx, 0, ifIndex, question,
 		func(flags Flags, ifIndex int, rr dns.RR) {
-			ptr := rr.(*dns.PTR)
+			// NSEC records telling there are no services are ignored.
+			ptr, ok := rr.(*dns.PTR)
+			if !ok {
+				return
+			}
 			serviceName, serviceType, domain := reformatServiceName(ptr.Ptr)
-			response(true, 0, ifIndex, serviceName, serviceType, domain)
+			response(flags&RecordAdded != 0, flags&MoreComing, ifIndex, serviceName, serviceType, domain)
 		}, errc)
 
 }
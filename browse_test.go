@@ -39,8 +39,55 @@ func TestBrowse(t *testing.T) {
 	assert.Equal(t, "hejsan", <-rrc)
 	assert.Equal(t, "hoppsan", <-rrc)
 
-	assert.Equal(t, 1, len(ds.ns.query.Question))
-	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.query.Question[0].String())
+	assert.Equal(t, 1, len(ds.ns.queryQuestions()))
+	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.queryQuestions()[0].String())
+}
+
+func TestBrowseRemoved(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Browse(ctx, 0, 0, "_raop._tcp", "local",
+		func(found bool, flags Flags, ifIndex int, serviceName, regType, domain string) {
+			rrc <- fmt.Sprint(found, ":", flags, ":", serviceName)
+		}, func(err error) {
+			rrc <- fmt.Sprint("TestBrowseRemoved err=", err)
+		})
+
+	ds.ns.msgCh <- fakeIncomingMsg(true).
+		addRR("_raop._tcp.local.", dns.TypePTR, "hejsan._raop._tcp.local.").
+		addRR("_raop._tcp.local.", dns.TypePTR, "hoppsan._raop._tcp.local.")
+	assert.Equal(t, "true:MoreComing:hejsan", <-rrc)
+	assert.Equal(t, "true:None:hoppsan", <-rrc)
+
+	ds.ns.msgCh <- fakeIncomingMsg(true).
+		addRR("_raop._tcp.local.", dns.TypePTR, "hejsan._raop._tcp.local.").ttl(0)
+	assertMessage(t, 2*time.Second, "false:None:hejsan", rrc)
+}
+
+func TestBrowseNoSuchRecord(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Browse(ctx, 0, 0, "_raop._tcp", "local",
+		func(found bool, flags Flags, ifIndex int, serviceName, regType, domain string) {
+			rrc <- fmt.Sprint(found, ":", serviceName)
+		}, func(err error) {
+			rrc <- fmt.Sprint("TestBrowseNoSuchRecord err=", err)
+		})
+
+	// An NSEC telling there are no PTR records is not a service.
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("_raop._tcp.local.", dns.TypeNSEC, dns.TypeSRV)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("_raop._tcp.local.", dns.TypePTR, "tjosan._raop._tcp.local.")
+	assertMessage(t, time.Second, "true:tjosan", rrc)
 }
 
 func TestBrowseAndResolve(t *testing.T) {
@@ -78,10 +125,10 @@ func TestBrowseAndResolve(t *testing.T) {
 
 	assert.Equal(t, "tjosan:www.facebook.it:4711:[hi=there]", <-rrc)
 
-	assert.Equal(t, 3, len(ds.ns.query.Question))
-	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.query.Question[0].String())
-	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t SRV", ds.ns.query.Question[1].String())
-	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t TXT", ds.ns.query.Question[2].String())
+	assert.Equal(t, 3, len(ds.ns.queryQuestions()))
+	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.queryQuestions()[0].String())
+	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t SRV", ds.ns.queryQuestions()[1].String())
+	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t TXT", ds.ns.queryQuestions()[2].String())
 }
 
 func TestBrowseAndResolveAndLookup(t *testing.T) {
@@ -143,10 +190,10 @@ func TestBrowseAndResolveAndLookup(t *testing.T) {
 	assert.Equal(t, "RESULT: serviceName=tjosan, ifIndex=2, hostName=www.facebook.it:4711, AAAA=192.168.112.77", <-rrc)
 
 	time.Sleep(1 * time.Millisecond)
-	assert.Equal(t, 5, len(ds.ns.query.Question))
-	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.query.Question[0].String())
-	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t SRV", ds.ns.query.Question[1].String())
-	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t TXT", ds.ns.query.Question[2].String())
-	assert.Equal(t, ";www.facebook.it\tIN\t A", ds.ns.query.Question[3].String())
-	assert.Equal(t, ";www.facebook.it\tIN\t AAAA", ds.ns.query.Question[4].String())
+	assert.Equal(t, 5, len(ds.ns.queryQuestions()))
+	assert.Equal(t, ";_raop._tcp.local.\tIN\t PTR", ds.ns.queryQuestions()[0].String())
+	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t SRV", ds.ns.queryQuestions()[1].String())
+	assert.Equal(t, ";tjosan._raop._tcp.local.\tIN\t TXT", ds.ns.queryQuestions()[2].String())
+	assert.Equal(t, ";www.facebook.it\tIN\t A", ds.ns.queryQuestions()[3].String())
+	assert.Equal(t, ";www.facebook.it\tIN\t AAAA", ds.ns.queryQuestions()[4].String())
 }
@@ -40,6 +40,19 @@ func (cb *callback) isClosed() bool {
 
 // return false if the callback is invalid and should be removed.
 func (cb *callback) respond(a *answer) bool {
+	return cb.deliver(a, false, None)
+}
+
+// Tell the callback that a record has been removed.
+// return false if the callback is invalid and should be removed.
+func (cb *callback) remove(a *answer) bool {
+	return cb.deliver(a, true, None)
+}
+
+// Deliver an answer to the callback. A removed answer has the RecordAdded
+// flag cleared, more may be MoreComing if there are more answers following.
+// return false if the callback is invalid and should be removed.
+func (cb *callback) deliver(a *answer, removed bool, more Flags) bool {
 	if cb.isClosed() {
 		return false
 	}
@@ -48,9 +61,9 @@ func (cb *callback) respond(a *answer) bool {
 		return true
 	}
 
-	flags := None
-	if a.ttl > 0 {
-		flags = RecordAdded
+	flags := more
+	if !removed && a.ttl > 0 {
+		flags |= RecordAdded
 	}
 	f := func() {
 		dnssdlog.Debug.Println("RUN CALLBACK:", cb)
@@ -0,0 +1,92 @@
+package dnssd
+
+import (
+	"time"
+)
+
+// Continuous querying, RFC6762 section 5.2. The first query of a
+// question is delayed 20-120ms, after that the interval starts at
+// one second and is doubled for each query up to 60 minutes.
+const (
+	queryDelay          = 20 * time.Millisecond
+	queryDelayVariation = 100 * time.Millisecond
+	queryInterval       = time.Second
+	maxQueryInterval    = 60 * time.Minute
+
+	// How often the interfaces are checked for topology changes.
+	topologyCheckInterval = 5 * time.Second
+)
+
+// Start querying for a question from the beginning of the schedule.
+func (ds *dnssd) startQuerying(cq *question) {
+	cq.interval = queryInterval
+	ds.sendContinuousQuery(cq, ds.nextSendAt(queryDelay+randomDuration(queryDelayVariation, 100)))
+	ds.nextCheckAt(time.Until(cq.next))
+}
+
+// Send a query for a question at a time, with our cached answers as
+// known answers, and schedule the next one.
+func (ds *dnssd) sendContinuousQuery(cq *question, at time.Time) {
+	dnssdlog.Debug.Println("QUERY=", cq.q, ", interval=", cq.interval)
+	if cq.unicast {
+		ds.ns.sendUnicastQuestion(cq.ifIndex, cq.q, at)
+		cq.unicast = false
+	} else {
+		ds.ns.sendQuestion(cq.ifIndex, cq.q, at)
+	}
+	f := func(a *answer) {
+		ds.sendKnownAnswer(cq.ifIndex, a)
+	}
+	ds.rrc.iterateAnswersForQuestion(cq.q, f)
+	ds.rrl.iterateAnswersForQuestion(cq.q, f)
+
+	cq.next = at.Add(cq.interval)
+	cq.interval *= 2
+	if cq.interval > maxQueryInterval {
+		cq.interval = maxQueryInterval
+	}
+}
+
+// Send the queries that are due and return the time of the next one.
+// Questions without callbacks are dropped and probe questions are never
+// queried. All questions start over when the network topology has changed.
+func (ds *dnssd) runContinuousQueries() time.Time {
+	now := time.Now()
+	ds.cs.removeInactive()
+	var cqs []*question
+	for _, cq := range ds.cs.qmap {
+		if cq.interval != 0 {
+			cqs = append(cqs, cq)
+		}
+	}
+	if len(cqs) == 0 {
+		return time.Time{}
+	}
+	if ds.topologyChanged(now) {
+		netlog.Info.Println("Network topology changed, restarting queries")
+		for _, cq := range cqs {
+			ds.startQuerying(cq)
+		}
+	}
+	next := ds.topoCheck
+	for _, cq := range cqs {
+		if !cq.next.After(now) {
+			ds.sendContinuousQuery(cq, ds.nextSendAt(0))
+		}
+		next = getNextTime(next, cq.next)
+	}
+	return next
+}
+
+// Check if the multicast interfaces or their addresses have changed
+// since the last check.
+func (ds *dnssd) topologyChanged(now time.Time) bool {
+	if now.Before(ds.topoCheck) {
+		return false
+	}
+	ds.topoCheck = now.Add(topologyCheckInterval)
+	topology := interfaceTopology()
+	changed := ds.topology != "" && ds.topology != topology
+	ds.topology = topology
+	return changed
+}
@@ -0,0 +1,108 @@
+package dnssd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContinuousQuerySchedule(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	cb := makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	start := time.Now()
+	ds.runQuery(None, 2, makeTestPtrQuestion("_tuting._tcp").q, cb)
+
+	assert.Equal(t, 1, len(ds.ns.questions))
+	at := ds.ns.questions[0].at
+	assert.True(t, at.Sub(start) >= queryDelay)
+	assert.True(t, at.Sub(start) <= queryDelay+queryDelayVariation+10*time.Millisecond)
+
+	cq := ds.cs.qmap[0]
+	assert.Equal(t, at.Add(time.Second), cq.next)
+	assert.Equal(t, 2*time.Second, cq.interval)
+
+	// Not due yet.
+	ds.ns.questions = nil
+	next := ds.runContinuousQueries()
+	assert.Equal(t, 0, len(ds.ns.questions))
+	assert.False(t, next.After(cq.next))
+
+	cq.next = time.Now()
+	ds.runContinuousQueries()
+	assert.Equal(t, 1, len(ds.ns.questions))
+	assert.Equal(t, 4*time.Second, cq.interval)
+
+	// The interval is capped.
+	cq.interval = 40 * time.Minute
+	cq.next = time.Now()
+	ds.runContinuousQueries()
+	assert.Equal(t, maxQueryInterval, cq.interval)
+}
+
+func TestContinuousQueryKnownAnswers(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	name := "_tuting._tcp"
+	cb := makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, makeTestPtrQuestion(name).q, cb)
+	assert.Equal(t, 0, len(ds.ns.knownAnswers))
+
+	ds.rrc.add(makeTestPtrAnswer(2, name, "fresh", 100))
+	ds.cs.qmap[0].next = time.Now()
+	ds.runContinuousQueries()
+	assert.Equal(t, 1, len(ds.ns.knownAnswerRecords()))
+}
+
+func TestContinuousQueryStop(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cb := makeCallback("test", 1, ctx, 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, makeTestPtrQuestion("_tuting._tcp").q, cb)
+
+	cancel()
+	ds.ns.questions = nil
+	ds.cs.qmap[0].next = time.Now()
+	assert.True(t, ds.runContinuousQueries().IsZero())
+	assert.Equal(t, 0, len(ds.ns.questions))
+	assert.Equal(t, 0, len(ds.cs.qmap))
+}
+
+func TestContinuousQueryTopologyChange(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	cb := makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, makeTestPtrQuestion("_tuting._tcp").q, cb)
+	cq := ds.cs.qmap[0]
+	cq.interval = 8 * time.Second
+	cq.next = time.Now().Add(time.Hour)
+
+	ds.topology = "changed"
+	ds.ns.questions = nil
+	ds.runContinuousQueries()
+	assert.Equal(t, 1, len(ds.ns.questions))
+	assert.Equal(t, 2*time.Second, cq.interval)
+	assert.True(t, cq.next.Before(time.Now().Add(2*time.Second)))
+
+	// No change until the next check.
+	assert.False(t, ds.topologyChanged(time.Now()))
+}
+
+func TestContinuousQueryProbe(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	rr := makeTestARecord("myhost.local.", "10.0.0.1")
+	q := questionFromRRHeader(rr.Header())
+	cb := makeCallback("probe", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runProbe(2, q, rr, cb)
+
+	// Probe questions are not queried continuously.
+	ds.ns.questions = nil
+	assert.True(t, ds.runContinuousQueries().IsZero())
+	assert.Equal(t, 0, len(ds.ns.questions))
+
+	// Unless someone queries for them.
+	cb = makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, q, cb)
+	assert.Equal(t, 1, len(ds.ns.questions))
+	assert.Equal(t, 2*time.Second, ds.cs.qmap[0].interval)
+}
@@ -5,24 +5,47 @@ package dnssd
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type dnssd struct {
-	ns       *netserver
-	cs       *questions
-	cmdCh    chan func()
-	rrc      *answers
-	rrl      *answers
-	ctxn     *contextNotifier
-	cn       chan context.Context
-	nextSend time.Time
+	ns        *netserver
+	cs        *questions
+	ps        *probes
+	cmdCh     chan func()
+	rrc       *answers
+	rrl       *answers
+	ctxn      *contextNotifier
+	cn        chan context.Context
+	nextSend  time.Time
+	nextCheck time.Time
+	started   time.Time
+	tqs       []*truncatedQuery
+	as        []*announcement
+	topology  string    // The multicast interfaces and their addresses.
+	topoCheck time.Time // When the topology is checked next.
+
+	nsecMulticast map[string]map[int]time.Time // When our NSEC record for a name was last multicast on each interface.
 }
 
 var ds *dnssd
 
+const (
+	// Delay and random variation added to shared answers.
+	sharedResponseDelay     = 20 * time.Millisecond
+	sharedResponseVariation = 100 * time.Millisecond
+
+	// Min time between multicasts of a record on an interface.
+	multicastInterval    = time.Second
+	probeDefenseInterval = 250 * time.Millisecond
+)
+
+// The max TTL given in responses to legacy unicast queries.
+const legacyMaxTTL = 10
+
 func getDnssd() *dnssd {
 	if ds == nil {
 		ns, err := makeNetserver()
@@ -30,10 +53,11 @@ func getDnssd() *dnssd {
 			panic("Could not start netserver")
 		}
 		cmdCh := make(chan func(), 32)
-		ds = &dnssd{ns: ns, cs: &questions{nil}, cmdCh: cmdCh, ctxn: initContextNotifier()}
+		ds = &dnssd{ns: ns, cs: &questions{nil}, ps: &probes{}, cmdCh: cmdCh, ctxn: initContextNotifier()}
 		ds.rrc = makeAnswers() // Remote entries, lookup only
 		ds.rrl = makeAnswers() // Local entries, repond and lookup.
 		ds.cn = ds.ctxn.getContextNotifications()
+		ds.started = time.Now()
 
 		go ds.processing()
 		startup()
@@ -41,11 +65,27 @@ func getDnssd() *dnssd {
 	return ds
 }
 
-func (ds *dnssd) nextSendAt(t time.Duration) {
+// Make sure pending records are sent within t. Return the time
+// a record scheduled now should be sent.
+func (ds *dnssd) nextSendAt(t time.Duration) time.Time {
 	nt := time.Now().Add(t)
 	if ds.nextSend.IsZero() || ds.nextSend.After(nt) {
 		ds.nextSend = nt
 	}
+	return nt
+}
+
+// Send records that are due and schedule the next send.
+func (ds *dnssd) sendPending() {
+	nt := ds.ns.sendPending()
+	if !nt.IsZero() {
+		ds.nextSend = getNextTime(ds.nextSend, nt)
+	}
+}
+
+// Make sure the timed events are checked within t.
+func (ds *dnssd) nextCheckAt(t time.Duration) {
+	ds.nextCheck = getNextTime(ds.nextCheck, time.Now().Add(t))
 }
 
 func (ds *dnssd) processing() {
@@ -60,6 +100,10 @@ func (ds *dnssd) processing() {
 	for {
 
 		now := time.Now()
+		if !ds.nextCheck.IsZero() {
+			nt = getNextTime(nt, ds.nextCheck)
+			ds.nextCheck = time.Time{}
+		}
 		if nt.IsZero() {
 			checkTimer.Stop()
 		} else if st != nt {
@@ -68,19 +112,18 @@ func (ds *dnssd) processing() {
 			checkTimer.Reset(st.Sub(now))
 		}
 
+		if !ds.nextSend.IsZero() && !now.Before(ds.nextSend) {
+			// Dont set an antedated timer...
+			nextSendTime = time.Time{}
+			ds.nextSend = nextSendTime
+			ds.sendPending()
+		}
 		if ds.nextSend.IsZero() {
 			sendTimer.Stop()
-		} else {
-			if now.After(ds.nextSend) {
-				// Dont set an antedated timer...
-				nextSendTime = time.Time{}
-				ds.nextSend = nextSendTime
-				ds.ns.sendPending()
-			} else if nextSendTime.IsZero() || nextSendTime.After(ds.nextSend) {
-				nextSendTime = ds.nextSend
-				delay := nextSendTime.Sub(now)
-				sendTimer.Reset(delay)
-			}
+		} else if nextSendTime.IsZero() || nextSendTime.After(ds.nextSend) {
+			nextSendTime = ds.nextSend
+			delay := nextSendTime.Sub(now)
+			sendTimer.Reset(delay)
 		}
 
 		select {
@@ -93,8 +136,8 @@ func (ds *dnssd) processing() {
 		case <-checkTimer.C:
 			nt = ds.checkRunningEvents()
 		case <-sendTimer.C:
+			// Pending records are sent at the top of the loop.
 			nextSendTime = time.Time{}
-			ds.ns.sendPending()
 		}
 	}
 }
@@ -110,8 +153,11 @@ func (ds *dnssd) handleClosedContext(ctx context.Context) time.Time {
 func (ds *dnssd) checkRunningEvents() time.Time {
 	t1 := ds.updateTTLOnPublishedRecords()
 	t2 := ds.requeryOldAnswers()
-	nt := getNextTime(t1, t2)
-	return nt
+	t3 := ds.answerTruncatedQueries()
+	t4 := ds.runAnnouncements()
+	t5 := ds.runContinuousQueries()
+	nt := getNextTime(getNextTime(t1, t2), getNextTime(t3, t4))
+	return getNextTime(nt, t5)
 }
 
 func (ds *dnssd) handleIncomingMessage(im *incomingMsg) {
@@ -120,41 +166,205 @@ func (ds *dnssd) handleIncomingMessage(im *incomingMsg) {
 	}
 
 	if im.msg.Response {
-		ds.handleResponseRecords(im, im.msg.Answer)
-		ds.handleResponseRecords(im, im.msg.Ns)
-		ds.handleResponseRecords(im, im.msg.Extra)
+		ds.ns.suppressDuplicateAnswers(im.ifIndex, im.msg.Answer)
+		// Deliver all records of the packet together so callbacks
+		// get MoreComing set.
+		b := &answerBatch{}
+		ds.handleResponseRecords(im, im.msg.Answer, b)
+		ds.handleResponseRecords(im, im.msg.Ns, b)
+		ds.handleResponseRecords(im, im.msg.Extra, b)
+		b.deliver()
+	} else if isLegacyQuery(im) {
+		ds.respondToLegacyQuery(im)
+	} else if ds.collectKnownAnswers(im) {
+		// Answered when all known answers have arrived.
+	} else {
+		ds.handleQuery(im)
+	}
+}
+
+// Respond to the questions of a query, suppressing answers known by the peer.
+// All answers to the query are scheduled together so they are sent in
+// one response.
+func (ds *dnssd) handleQuery(im *incomingMsg) {
+	if len(im.msg.Ns) > 0 {
+		ds.handleIncomingProbe(im)
 	} else {
-		// Check each question find matching answers and remove
-		// any already known by peer.
-		for _, q := range im.msg.Question {
-			qlog.Info.Println("Question from", im.from, "=", q.String)
-			matchedResponses := ds.rrl.matchQuestion(&q)
-		nextMatchedResponse:
-			for _, mr := range matchedResponses {
-				for _, kr := range im.msg.Answer {
-					if matchRRs(mr.rr, kr) {
-						// Already known by peer so...
-						continue nextMatchedResponse
-					}
+		ds.ns.suppressDuplicateQuestions(im.ifIndex, im.msg)
+	}
+	// Check each question find matching answers and remove
+	// any already known by peer.
+	var answers []*answer
+	var multicast []bool
+	var negatives []dns.Question
+	negativeUnicast := true
+	allUnique := true
+	for _, q := range im.msg.Question {
+		qlog.Info.Println("Question from", im.from, "=", q.String)
+		unicast := q.Qclass&unicastResponseBit != 0
+		q.Qclass &^= unicastResponseBit
+		matchedResponses := ds.rrl.matchQuestionOnInterface(im.ifIndex, &q)
+		if len(matchedResponses) == 0 && q.Qtype != dns.TypeANY {
+			negatives = append(negatives, q)
+			negativeUnicast = negativeUnicast && unicast
+		}
+		for _, mr := range matchedResponses {
+			if mr.isKnownAnswer(im.msg.Answer) {
+				// Already known by peer so...
+				continue
+			}
+			// Multicast the answer if any question asking for it wants it.
+			multicastIt := !unicast || im.from == nil || !mr.multicastRecently(im.ifIndex)
+			found := false
+			for ii, a := range answers {
+				if a == mr {
+					multicast[ii] = multicast[ii] || multicastIt
+					found = true
 				}
-				if mr.flags&Unique != 0 {
-					ds.nextSendAt(0)
-				} else {
-					ds.nextSendAt(randomDuration(500*time.Millisecond, 100))
+			}
+			if !found {
+				answers = append(answers, mr)
+				multicast = append(multicast, multicastIt)
+				allUnique = allUnique && mr.flags&Unique != 0
+			}
+		}
+	}
+	if len(answers) == 0 && len(negatives) == 0 {
+		return
+	}
+
+	// Unique answers are sent immediately, shared answers are delayed
+	// 20-120ms to aggregate answers from other responders, RFC6762 section 6.
+	var at time.Time
+	if allUnique {
+		at = ds.nextSendAt(0)
+	} else {
+		at = ds.nextSendAt(sharedResponseDelay + randomDuration(sharedResponseVariation, 100))
+	}
+	// A record is multicast at most once per second, or 250ms when
+	// defending it against a probe.
+	limit := multicastInterval
+	if len(im.msg.Ns) > 0 {
+		limit = probeDefenseInterval
+	}
+	for _, q := range negatives {
+		ds.sendNegativeResponse(im, &q, negativeUnicast, at, limit)
+	}
+	for ii, mr := range answers {
+		var to net.Addr
+		// Additional records are sent with the answer, which may be
+		// later than at if it was multicast recently.
+		sat := at
+		if !multicast[ii] {
+			// Peers have seen it recently so only the querier needs it.
+			qlog.Info.Println("Unicast Response:", mr.rr, "to", im.from)
+			to = im.from
+			ds.ns.sendUnicastResponseRecord(im.ifIndex, mr.rr, mr.flags, to, at)
+		} else {
+			mat := at
+			if earliest := mr.lastMulticastOn(im.ifIndex).Add(limit); mat.Before(earliest) {
+				mat = ds.nextSendAt(time.Until(earliest))
+			}
+			qlog.Info.Println("Response:", mr.rr, "at", mat)
+			ds.ns.sendResponseRecord(im.ifIndex, mr.rr, mr.flags, mat)
+			sat = ds.ns.responseTime(im.ifIndex, mr.rr, mat)
+			mr.multicastAt(im.ifIndex, sat)
+		}
+		names := []string{mr.rr.Header().Name}
+		for _, ar := range ds.additionalAnswers(im.ifIndex, mr.rr) {
+			if !ar.isKnownAnswer(im.msg.Answer) {
+				ds.ns.sendAdditionalRecord(im.ifIndex, ar.rr, ar.flags, to, sat)
+				names = appendIfMissingName(names, ar.rr.Header().Name)
+			}
+		}
+		// Tell the querier which types exist for the names we own.
+		for _, name := range names {
+			if nsec := ds.makeNSEC(im.ifIndex, name); nsec != nil && !containsRecord(im.msg.Answer, nsec) {
+				ds.ns.sendAdditionalRecord(im.ifIndex, nsec, Unique, to, sat)
+			}
+		}
+	}
+}
+
+// Answer a question for a type we don't have for a name we own with
+// an NSEC record so the querier stops asking, RFC6762 section 6.1.
+// Like other records the NSEC record for a name is multicast at most
+// once per limit on an interface.
+func (ds *dnssd) sendNegativeResponse(im *incomingMsg, q *dns.Question, unicast bool, at time.Time, limit time.Duration) {
+	nsec := ds.makeNSEC(im.ifIndex, q.Name)
+	if nsec == nil || containsRecord(im.msg.Answer, nsec) {
+		return
+	}
+	if unicast && im.from != nil {
+		qlog.Info.Println("Unicast Negative Response:", nsec, "to", im.from)
+		ds.ns.sendUnicastResponseRecord(im.ifIndex, nsec, Unique, im.from, at)
+		return
+	}
+	name := q.Name
+	if earliest := ds.lastNSECMulticastOn(im.ifIndex, name).Add(limit); at.Before(earliest) {
+		at = ds.nextSendAt(time.Until(earliest))
+	}
+	qlog.Info.Println("Negative Response:", nsec, "at", at)
+	ds.ns.sendResponseRecord(im.ifIndex, nsec, Unique, at)
+	ds.nsecMulticastAt(im.ifIndex, name, ds.ns.responseTime(im.ifIndex, nsec, at))
+}
+
+// Queries from a source port other than 5353 come from simple resolvers
+// that can't receive multicast responses, RFC6762 section 6.7.
+func isLegacyQuery(im *incomingMsg) bool {
+	ua, ok := im.from.(*net.UDPAddr)
+	return ok && ua.Port != 5353
+}
+
+// Respond directly to a legacy resolver. The response is sent
+// immediately echoing the query id and questions.
+func (ds *dnssd) respondToLegacyQuery(im *incomingMsg) {
+	msg := ds.makeLegacyResponse(im)
+	if len(msg.Answer) > 0 {
+		qlog.Info.Println("Legacy Response to", im.from, "=", msg)
+		ds.ns.sendUnicastMessage(im.ifIndex, msg, im.from)
+	}
+}
+
+// Build a response to a legacy query. TTLs are capped at 10 seconds
+// and cache-flush bits are cleared since the resolver won't expect them.
+func (ds *dnssd) makeLegacyResponse(im *incomingMsg) *dns.Msg {
+	msg := &dns.Msg{}
+	msg.Id = im.msg.Id
+	msg.Response = true
+	msg.Authoritative = true
+	for _, q := range im.msg.Question {
+		q.Qclass &^= unicastResponseBit
+		msg.Question = append(msg.Question, q)
+		for _, mr := range ds.rrl.matchQuestionOnInterface(im.ifIndex, &q) {
+			rr := dns.Copy(mr.rr)
+			rr.Header().Class &^= cacheFlushBit
+			if rr.Header().Ttl > legacyMaxTTL {
+				rr.Header().Ttl = legacyMaxTTL
+			}
+			msg.Answer = append(msg.Answer, rr)
+			for _, ar := range ds.additionalAnswers(im.ifIndex, mr.rr) {
+				rr := dns.Copy(ar.rr)
+				if rr.Header().Ttl > legacyMaxTTL {
+					rr.Header().Ttl = legacyMaxTTL
+				}
+				if !containsRecord(msg.Extra, rr) {
+					msg.Extra = append(msg.Extra, rr)
 				}
-				qlog.Info.Println("Response:", mr.rr)
-				ds.ns.sendResponseRecord(im.ifIndex, mr.rr)
 			}
 		}
 	}
+	msg.Extra = withoutRecords(msg.Extra, msg.Answer)
+	return msg
 }
 
-func (ds *dnssd) publish(ctx context.Context, flags Flags, ifIndex int, record dns.RR) {
+// Publish a record and start announcing it.
+func (ds *dnssd) publish(ctx context.Context, flags Flags, ifIndex int, record dns.RR, conflict func(rr dns.RR)) {
 	ds.ctxn.addContextForNotifications(ctx)
 	a, _ := ds.rrl.addRecord(ctx, flags, ifIndex, record)
+	a.conflict = conflict
 	ds.rrl.add(a)
-	ds.nextSendAt(10 * time.Millisecond)
-	ds.ns.sendResponseRecord(ifIndex, a.rr)
+	ds.startAnnouncement(a)
 
 	cq := ds.cs.findQuestionFromRR(a.rr)
 	if cq != nil {
@@ -162,73 +372,187 @@ func (ds *dnssd) publish(ctx context.Context, flags Flags, ifIndex int, record d
 	}
 }
 
-// Check all cached RR entries and send a question for more
-// data.
-func (ds *dnssd) runQuery(ifIndex int, q *dns.Question, cb *callback) {
+// Replace the data of a published record, e.g. an updated TXT record,
+// and announce it again from the start, RFC6762 section 8.4.
+func (ds *dnssd) update(ifIndex int, old, record dns.RR) {
+	a := ds.rrl.findAnswer(ifIndex, old)
+	if a == nil {
+		return
+	}
+	dnssdlog.Debug.Println("Updated:", a, ", to=", record)
+	a.rr = record
+	a.ttl = time.Second * time.Duration(record.Header().Ttl)
+	a.added = time.Now()
+	a.requeried = 0
+	ds.startAnnouncement(a)
+
+	for _, cq := range ds.cs.findQuestionsFromRR(a.rr) {
+		cq.respond(a)
+	}
+}
+
+// Stop answering for a published record without sending a goodbye.
+func (ds *dnssd) unpublish(ifIndex int, record dns.RR) {
+	if a := ds.rrl.findAnswer(ifIndex, record); a != nil {
+		ds.stopAnnouncement(a)
+	}
+	ds.rrl.removeRecord(ifIndex, record)
+}
+
+// Check all cached RR entries and start querying for more
+// data. The first question is sent as QU if requested by the flags or
+// if we just started and have an empty cache, RFC6762 section 5.4.
+// A question asked on several interfaces is queried on all of them.
+func (ds *dnssd) runQuery(flags Flags, ifIndex int, q *dns.Question, cb *callback) {
 	// Find a currently running query and attach this command.
 	cq := ds.cs.findQuestion(q)
 	ds.ctxn.addContextForNotifications(cb.ctx)
 
 	// Check the cache for all entries matching and respond with these.
+	var cached []*answer
 	f := func(a *answer) {
 		dnssdlog.Debug.Println("ANSWER ", a)
-		cb.respond(a)
-		if cq == nil {
-			// Only add known answers if we intend to ask a question
-			ds.nextSendAt(500 * time.Millisecond)
-			ds.ns.sendKnownAnswer(ifIndex, a.rr)
-		}
+		cached = append(cached, a)
 	}
 	ds.rrc.iterateAnswersForQuestion(q, f)
 	ds.rrl.iterateAnswersForQuestion(q, f)
 
-	if cq == nil {
-		cq = ds.cs.makeQuestion(q)
+	// A question only used by probes so far is not queried yet.
+	if cq == nil || !cq.isActive() || cq.interval == 0 {
+		if cq == nil {
+			cq = ds.cs.makeQuestion(q)
+		}
 		cq.attach(cb)
-		ds.nextSendAt(10 * time.Millisecond)
-		ds.ns.sendQuestion(ifIndex, q)
+		cq.ifIndex = ifIndex
+		cq.unicast = flags&UnicastResponse != 0 || time.Since(ds.started) < time.Second
+		ds.startQuerying(cq)
 	} else {
+		if cq.ifIndex != ifIndex {
+			cq.ifIndex = 0
+		}
 		cq.attach(cb)
 	}
+
+	for ii, a := range cached {
+		more := None
+		if ii < len(cached)-1 {
+			more = MoreComing
+		}
+		cq.deliverTo(cb, a, false, more)
+	}
 }
 
-// Start a probe query. Will not check the cache
-func (ds *dnssd) runProbe(ifIndex int, q *dns.Question, cb *callback) {
-	cq := ds.cs.makeQuestion(q)
+// Add a cached answer to the known answers of the next query if more
+// than half of its TTL remains. The remaining TTL is sent so the responder
+// can decide if it needs to refresh it, RFC6762 section 7.1.
+func (ds *dnssd) sendKnownAnswer(ifIndex int, a *answer) {
+	ttl := a.remainingTTL()
+	if ttl*2 <= a.rr.Header().Ttl {
+		return
+	}
+	rr := dns.Copy(a.rr)
+	rr.Header().Ttl = ttl
+	ds.ns.sendKnownAnswer(ifIndex, rr)
+}
+
+// Start a probe query. Will not check the cache. The record
+// probed for is sent in the authority section.
+func (ds *dnssd) runProbe(ifIndex int, q *dns.Question, rr dns.RR, cb *callback) {
+	// Reuse a question from a previous probe so the response
+	// reaches this callback.
+	cq := ds.cs.findQuestion(q)
+	if cq == nil {
+		cq = ds.cs.makeQuestion(q)
+	}
 	cq.attach(cb)
-	ds.nextSendAt(10 * time.Millisecond)
-	ds.ns.sendQuestion(ifIndex, q)
+	ds.ns.sendProbe(ifIndex, q, rr, ds.nextSendAt(10*time.Millisecond))
 }
 
-func (ds *dnssd) handleResponseRecords(im *incomingMsg, rrs []dns.RR) {
+func (ds *dnssd) handleResponseRecords(im *incomingMsg, rrs []dns.RR, b *answerBatch) {
 	ifIndex := im.ifIndex
 	for _, rr := range rrs {
 		qlog.Debug.Println("Record from=", im.from, "=", rr)
-		cacheFlush := rr.Header().Class&0x8000 != 0
+		cacheFlush := rr.Header().Class&cacheFlushBit != 0
 		flags := Shared
 		if cacheFlush {
 			flags = Unique
 		}
-		rr.Header().Class &= 0x7fff
-		// TODO: Is this a response or a challenge?
-		cq := ds.cs.findQuestionFromRR(rr)
+		rr.Header().Class &^= cacheFlushBit
+		if rr.Header().Ttl == 0 {
+			ds.handleGoodbye(ifIndex, rr)
+			continue
+		}
+		if cacheFlush {
+			ds.handleCacheFlush(ifIndex, rr)
+		}
+		ds.handleStaleNSEC(ifIndex, rr, b)
 		a, isNew := ds.rrc.addRecord(nil, flags, ifIndex, rr)
-		if cq != nil && isNew {
-			cq.respond(a)
+		// A running probe must see every response for its name, even
+		// if the record is already cached.
+		if isNew || len(ds.ps.records(rr.Header().Name)) > 0 {
+			for _, cq := range ds.cs.findQuestionsFromRR(rr) {
+				b.respond(cq, a)
+			}
 		}
 
-		challenge, ok := ds.rrl.findAnswerFromRR(rr)
-		if ok {
-			// We have a challenge record.
-			if challenge.flags&Unique != 0 {
-				ds.nextSendAt(0)
-				dnssdlog.Debug.Println("CHALLENGE!, ", rr, challenge)
-				ds.ns.sendResponseRecord(ifIndex, challenge.rr)
+		challenge := ds.rrl.findConflict(rr)
+		if challenge != nil {
+			// Someone is claiming our unique record with different data.
+			dnssdlog.Debug.Println("CHALLENGE!, ", rr, challenge)
+			if challenge.conflict != nil {
+				challenge.conflict(rr)
+			} else {
+				ds.ns.sendResponseRecord(ifIndex, challenge.rr, challenge.flags, ds.nextSendAt(0))
 			}
 		}
 	}
 }
 
+// A record with TTL zero is a goodbye from a host that is leaving or
+// withdrawing the record. The cached record is removed after one second,
+// RFC6762 section 10.1.
+func (ds *dnssd) handleGoodbye(ifIndex int, rr dns.RR) {
+	a := ds.rrc.findAnswer(ifIndex, rr)
+	if a != nil {
+		dnssdlog.Debug.Println("Goodbye:", a)
+		a.expireIn(time.Second)
+		ds.nextCheckAt(time.Second)
+	}
+}
+
+// A record with the cache-flush bit set is the complete rrset from its
+// owner. Other cached records in the rrset received more than one second
+// ago are removed after one second, RFC6762 section 10.2.
+func (ds *dnssd) handleCacheFlush(ifIndex int, rr dns.RR) {
+	old := time.Now().Add(-time.Second)
+	ds.rrc.iterateRRSet(ifIndex, rr, func(a *answer) {
+		if a.added.Before(old) && !matchRRData(a.rr, rr) {
+			dnssdlog.Debug.Println("Cache flush:", a)
+			a.expireIn(time.Second)
+			ds.nextCheckAt(time.Second)
+		}
+	})
+}
+
+// A received record tells that cached NSEC records for its name denying
+// the type of the record are out of date, they are removed at once.
+func (ds *dnssd) handleStaleNSEC(ifIndex int, rr dns.RR, b *answerBatch) {
+	q := questionFromRRHeader(rr.Header())
+	var stale []*answer
+	for _, a := range ds.rrc.cache {
+		if a.ifIndex == ifIndex && a.rr.Header().Name == q.Name && isNegativeAnswer(q, a.rr) {
+			stale = append(stale, a)
+		}
+	}
+	for _, a := range stale {
+		dnssdlog.Debug.Println("Stale NSEC:", a, ", by=", rr)
+		ds.rrc.removeRecord(ifIndex, a.rr)
+		for _, cq := range ds.cs.findQuestionsFromRR(a.rr) {
+			b.remove(cq, a)
+		}
+	}
+}
+
 // Look through ds.rrl for records which are about to expire
 // and republish them unless their context has cancelled them
 // Return a time for next published record to update TTL for
@@ -238,13 +562,13 @@ func (ds *dnssd) updateTTLOnPublishedRecords() time.Time {
 		a.added = time.Now()
 		a.requeried = 0
 		dnssdlog.Debug.Println("SENDING REPUBLISH..", a.rr)
-		ds.nextSendAt(100 * time.Millisecond)
-		ds.ns.sendResponseRecord(a.ifIndex, a.rr)
+		at := ds.nextSendAt(100 * time.Millisecond)
+		a.multicastAt(a.ifIndex, at)
+		ds.ns.sendResponseRecord(a.ifIndex, a.rr, a.flags, at)
 	}, func(a *answer) {
 		a.rr.Header().Ttl = 0
 		dnssdlog.Debug.Println("SENDING UNPUBLISH..", a.rr)
-		ds.nextSendAt(100 * time.Millisecond)
-		ds.ns.sendResponseRecord(a.ifIndex, a.rr)
+		ds.ns.sendResponseRecord(a.ifIndex, a.rr, a.flags, ds.nextSendAt(100*time.Millisecond))
 	})
 }
 
@@ -253,19 +577,39 @@ func (ds *dnssd) updateTTLOnPublishedRecords() time.Time {
 // the answer.
 // Return a time for next record to requery.
 func (ds *dnssd) requeryOldAnswers() time.Time {
-	return ds.rrc.findOldAnswers(func(a *answer) {
-		// Requery the record if we have a question for it...
-		q := ds.cs.findQuestionFromRR(a.rr)
-		if q != nil && q.isActive() {
-			ds.nextSendAt(100 * time.Millisecond)
-			ds.ns.sendQuestion(a.ifIndex, q.q)
+	b := &answerBatch{}
+	defer b.deliver()
+	var requeries []*answer
+	defer func() {
+		for _, a := range requeries {
+			ds.requery(a)
 		}
+	}()
+	return ds.rrc.findOldAnswers(func(a *answer) {
+		requeries = append(requeries, a)
 	}, func(a *answer) {
 		// The record has been removed
 		dnssdlog.Debug.Println("Record removed:", a)
+		for _, q := range ds.cs.findQuestionsFromRR(a.rr) {
+			b.remove(q, a)
+		}
 	})
 }
 
+// Requery a record if we have a question for it. Other cached
+// answers to the question are sent as known answers.
+func (ds *dnssd) requery(a *answer) {
+	q := ds.cs.findQuestionFromRR(a.rr)
+	if q != nil && q.isActive() {
+		ds.ns.sendQuestion(a.ifIndex, q.q, ds.nextSendAt(100*time.Millisecond))
+		ds.rrc.iterateAnswersForQuestion(q.q, func(ka *answer) {
+			if ka.ifIndex == a.ifIndex {
+				ds.sendKnownAnswer(a.ifIndex, ka)
+			}
+		})
+	}
+}
+
 // Shutdown server will close currently open connections & channel
 func (ds *dnssd) shutdown() error {
 	close(ds.cmdCh)
@@ -1,7 +1,10 @@
 package dnssd
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -13,19 +16,17 @@ func makeTestDnssd(t *testing.T) (*dnssd, chan func()) {
 	assert.NoError(t, err)
 	assert.NotNil(t, ns)
 
-	ds = &dnssd{ns: ns, cs: &questions{nil}, cmdCh: cmdCh, ctxn: initContextNotifier()}
+	ds = &dnssd{ns: ns, cs: &questions{nil}, ps: &probes{}, cmdCh: cmdCh, ctxn: initContextNotifier()}
 	ds.rrc = makeAnswers() // Remote entries, lookup only
 	ds.rrl = makeAnswers() // Local entries, repond and lookup.
 	ds.cn = ds.ctxn.getContextNotifications()
 
-	testlog.Debug.Println("response=", ds.ns.response)
 	return ds, cmdCh
 }
 
 func (ds *dnssd) addPublishedAnswer(name string, ifIndex int) {
 	a := makeTestPtrAnswer(ifIndex, name, "hoppla", 12000)
 	ds.rrl.add(a)
-	testlog.Debug.Println("response=", ds.ns.response)
 
 }
 func (ds *dnssd) runTestQuestion(name string, ifIndex int) {
@@ -43,8 +44,169 @@ func TestHandleIncomingMessageQuestion(t *testing.T) {
 	ds.addPublishedAnswer(name, ifIndex)
 	ds.runTestQuestion(name, ifIndex)
 
-	responseMsg := ds.ns.response
-	testlog.Debug.Println("response=", ds.ns.response)
-	assert.NotNil(t, responseMsg)
-	assert.Equal(t, 1, len(responseMsg.Answer))
+	responses := ds.ns.responseRecords()
+	testlog.Debug.Println("response=", responses)
+	assert.Equal(t, 1, len(responses))
+}
+
+func TestHandleIncomingMessageKnownAnswer(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ifIndex := 2
+	name := "_tuting._tcp"
+	ds.addPublishedAnswer(name, ifIndex)
+
+	// The peer knows the answer with more than half our TTL left.
+	im := fakeIncomingMsg(false).addRR(name, dns.TypePTR, "hoppla").ttl(7000)
+	im.msg.Question = []dns.Question{*makeTestPtrQuestion(name).q}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 0, len(ds.ns.responseRecords()))
+
+	// The peer knows the answer but it is about to expire.
+	im = fakeIncomingMsg(false).addRR(name, dns.TypePTR, "hoppla").ttl(5000)
+	im.msg.Question = []dns.Question{*makeTestPtrQuestion(name).q}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responseRecords()))
+}
+
+func TestRunQueryKnownAnswers(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	name := "_tuting._tcp"
+
+	fresh := makeTestPtrAnswer(2, name, "fresh", 100)
+	ds.rrc.add(fresh)
+	fresh.added = time.Now().Add(-10 * time.Second)
+	old := makeTestPtrAnswer(2, name, "old", 100)
+	ds.rrc.add(old)
+	old.added = time.Now().Add(-60 * time.Second)
+
+	cb := makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, makeTestPtrQuestion(name).q, cb)
+
+	assert.Equal(t, 1, len(ds.ns.knownAnswerRecords()))
+	assert.Equal(t, "_tuting._tcp\t89\tIN\tPTR\tfresh", ds.ns.knownAnswerRecords()[0].String())
+}
+
+func TestHandleIncomingMessageUnicastQuestion(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ifIndex := 2
+	name := "_tuting._tcp"
+	ds.addPublishedAnswer(name, ifIndex)
+	q := makeTestPtrQuestion(name).q
+	q.Qclass |= unicastResponseBit
+
+	// Not multicast recently so it should be multicast.
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{*q}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Nil(t, ds.ns.responses[0].to)
+
+	// Multicast recently so only the querier gets it.
+	ds.ns.responses = nil
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, im.from, ds.ns.responses[0].to)
+}
+
+func TestRunQueryUnicast(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	cb := makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(UnicastResponse, 2, makeTestPtrQuestion("_tuting._tcp").q, cb)
+	assert.Equal(t, 1, len(ds.ns.questions))
+	assert.True(t, ds.ns.questions[0].unicast)
+
+	cb = makeCallback("test", 1, context.Background(), 0, func(flags Flags, ifIndex int, rr dns.RR) {})
+	ds.runQuery(None, 2, makeTestPtrQuestion("_tjohej._tcp").q, cb)
+	assert.Equal(t, 2, len(ds.ns.questions))
+	assert.False(t, ds.ns.questions[1].unicast)
+}
+
+func TestLegacyQuery(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	name := "_tuting._tcp"
+	a := makeTestPtrAnswer(2, name, "hoppla", 12000)
+	a.flags = Unique
+	ds.rrl.add(a)
+
+	im := fakeIncomingMsg(false)
+	assert.False(t, isLegacyQuery(im))
+	im.from.(*net.UDPAddr).Port = 40000
+	assert.True(t, isLegacyQuery(im))
+
+	im.msg.Id = 4711
+	im.msg.Question = []dns.Question{*makeTestPtrQuestion(name).q}
+	msg := ds.makeLegacyResponse(im)
+	assert.Equal(t, uint16(4711), msg.Id)
+	assert.Equal(t, im.msg.Question, msg.Question)
+	assert.Equal(t, 1, len(msg.Answer))
+	assert.Equal(t, "_tuting._tcp\t10\tIN\tPTR\thoppla", msg.Answer[0].String())
+
+	// Legacy queries are not scheduled as multicast responses.
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 0, len(ds.ns.responses))
+}
+
+func TestHandleQueryMultipleQuestions(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{
+		{Name: "Stryfnake._tuting._tcp.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET},
+		{Name: "Stryfnake._tuting._tcp.local.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET},
+		{Name: "myhost.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+	}
+	ds.handleIncomingMessage(im)
+
+	// All answers are sent together in one response.
+	assert.Equal(t, 3, len(ds.ns.responses))
+	for _, pr := range ds.ns.responses {
+		assert.Equal(t, ds.ns.responses[0].at, pr.at)
+	}
+	// The A record is an answer and not repeated as an additional record.
+	assert.Equal(t, 1, len(ds.ns.additionals))
+	assert.Equal(t, 0, len(withoutRecords(additionalRecords(ds.ns.additionals, 2, nil), ds.ns.responseRecords())))
+}
+
+func TestHandleQueryANY(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{{Name: "Stryfnake._tuting._tcp.local.", Qtype: dns.TypeANY, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+
+	assert.Equal(t, 2, len(ds.ns.responses))
+	assert.Equal(t, dns.TypeSRV, ds.ns.responses[0].rr.Header().Rrtype)
+	assert.Equal(t, dns.TypeTXT, ds.ns.responses[1].rr.Header().Rrtype)
+}
+
+func TestHandleQueryRateLimit(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ifIndex := 2
+	name := "_tuting._tcp"
+	ds.addPublishedAnswer(name, ifIndex)
+	a := ds.rrl.cache[0]
+
+	// Shared answers are aggregated for 20-120ms.
+	now := time.Now()
+	ds.runTestQuestion(name, ifIndex)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	delay := ds.ns.responses[0].at.Sub(now)
+	assert.True(t, delay >= 20*time.Millisecond && delay <= 121*time.Millisecond, delay)
+	assert.Equal(t, ds.ns.responses[0].at, a.lastMulticastOn(ifIndex))
+
+	// Not multicast again within a second.
+	ds.ns.responses = nil
+	last := time.Now().Add(-500 * time.Millisecond)
+	a.multicastAt(ifIndex, last)
+	ds.runTestQuestion(name, ifIndex)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.False(t, ds.ns.responses[0].at.Before(last.Add(time.Second)))
+
+	// Answers to a question on another interface are not delayed.
+	ds.ns.responses = nil
+	a.ifIndex = 0
+	ds.runTestQuestion(name, 3)
+	assert.True(t, ds.ns.responses[0].at.Before(last.Add(time.Second)))
 }
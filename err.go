@@ -2,6 +2,9 @@ package dnssd
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
 )
 
 /*
@@ -11,3 +14,19 @@ a dnssd call. err is the error that occurred.
 type ErrCallback func(err error)
 
 var errBadFlags error = errors.New("Bad Flags")
+var errNoSuchRecord error = errors.New("No such record")
+
+/*
+ConflictError is reported to the ErrCallback when a unique record could not
+be registered because another host on the network claims the same name and
+automatic renaming has been disabled with NoAutoRename. Record is the record
+we tried to register and Conflict is the record received from the other host.
+*/
+type ConflictError struct {
+	Record   dns.RR
+	Conflict dns.RR
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprint("Name conflict for '", e.Record.Header().Name, "' with ", e.Conflict)
+}
@@ -66,6 +66,16 @@ const (
 		True if a record was added. False it was lost.
 	*/
 	RecordAdded Flags = 1 << iota
+
+	/*
+		Request unicast responses to a query by setting the QU bit on the first question sent.
+	*/
+	UnicastResponse Flags = 1 << iota
+
+	/*
+		Set on a query answer when the record is an NSEC record telling that the record asked for doesn't exist.
+	*/
+	NoSuchRecord Flags = 1 << iota
 )
 
 func (f Flags) appendString(b *bytes.Buffer, mask Flags, name string) {
@@ -88,6 +98,8 @@ func (f Flags) String() string {
 	f.appendString(b, BrowseDomains, "BrowseDomains")
 	f.appendString(b, RegistrationDomains, "RegistrationDomains")
 	f.appendString(b, RecordAdded, "RecordAdded")
+	f.appendString(b, UnicastResponse, "UnicastResponse")
+	f.appendString(b, NoSuchRecord, "NoSuchRecord")
 	if b.Len() == 0 {
 		return "None"
 	}
@@ -0,0 +1,109 @@
+package dnssd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	hostLock      sync.Mutex
+	hostName      string // The first label of our host name, e.g. "myhost".
+	hostListeners []hostListener
+)
+
+// A function called with the new host name when the host is renamed.
+type hostListener struct {
+	ctx context.Context
+	f   func(name string)
+}
+
+/*
+HostName returns the name our addresses are published under, e.g. "myhost.local.".
+It starts out as the name of the computer and is renamed "myhost-2.local.", "myhost-3.local.", ...
+if the name is in use by another host on the network. Services registered without a host
+follow the renames, their SRV records are registered again with the new name as target.
+*/
+func HostName() string {
+	return fmt.Sprintf("%s.%s.", getHostLabel(), getOwnDomainname())
+}
+
+func getHostLabel() string {
+	hostLock.Lock()
+	defer hostLock.Unlock()
+	if hostName == "" {
+		hostName = getDefaultHostLabel()
+	}
+	return hostName
+}
+
+func setHostLabel(label string) {
+	hostLock.Lock()
+	defer hostLock.Unlock()
+	hostName = label
+}
+
+// Call f with the new host name every time the host is renamed until
+// ctx is closed.
+func onHostRename(ctx context.Context, f func(name string)) {
+	hostLock.Lock()
+	defer hostLock.Unlock()
+	hostListeners = append(hostListeners, hostListener{ctx, f})
+}
+
+// Tell the listeners that the host has been renamed. Listeners with a
+// closed context are dropped.
+func notifyHostRename(name string) {
+	hostLock.Lock()
+	var fs []func(name string)
+	jj := 0
+	for _, hl := range hostListeners {
+		if !contextIsClosed(hl.ctx) {
+			hostListeners[jj] = hl
+			jj++
+			fs = append(fs, hl.f)
+		}
+	}
+	hostListeners = hostListeners[0:jj]
+	hostLock.Unlock()
+
+	for _, f := range fs {
+		f(name)
+	}
+}
+
+// The first label of the computer name.
+func getDefaultHostLabel() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		dnssdlog.Info.Println("Could not get host name:", err)
+		return "localhost"
+	}
+	label, _ := splitFirstLabel(h)
+	return label
+}
+
+// Publish the addresses of all interfaces as unique records under our
+// host name. The records are probed for and all of them are published
+// again under a new name if any of them is in conflict. The records
+// are withdrawn when pctx is closed.
+func publishHost(pctx context.Context, label string) {
+	ctx, cancel := context.WithCancel(pctx)
+	var renameOnce sync.Once
+	setHostLabel(label)
+	name := HostName()
+	publishInterfaceAddrs(ctx, Unique|NoAutoRename, name, func(err error) {
+		if _, ok := err.(*ConflictError); !ok {
+			dnssdlog.Info.Println("DNSSD HOST ERROR=", err)
+			return
+		}
+		renameOnce.Do(func() {
+			cancel()
+			alabel, _ := splitFirstLabel(alternativeName(name))
+			dnssdlog.Info.Println("DNSSD RENAME HOST=", label, "-->", alabel)
+			publishHost(pctx, alabel)
+			notifyHostRename(HostName())
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package dnssd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostName(t *testing.T) {
+	assert.False(t, strings.Contains(getDefaultHostLabel(), "."))
+
+	setHostLabel("tuting")
+	assert.Equal(t, "tuting.local.", HostName())
+	setHostLabel("")
+	assert.Equal(t, getDefaultHostLabel()+".local.", HostName())
+}
+
+func TestPublishHostRename(t *testing.T) {
+	ifIndex := 0
+	for _, iface := range multicastInterfaces() {
+		if len(interfaceAddresses(&iface)) > 0 {
+			ifIndex = iface.Index
+		}
+	}
+	if ifIndex == 0 {
+		t.Skip("No interface addresses to publish")
+	}
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+	defer setHostLabel("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publishHost(ctx, "tuting")
+	assert.Equal(t, "tuting.local.", HostName())
+
+	time.Sleep(10 * time.Millisecond)
+	im := fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.99.99.99")
+	im.ifIndex = ifIndex
+	ds.ns.msgCh <- im
+
+	for ii := 0; ii < 100 && HostName() == "tuting.local."; ii++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "tuting-2.local.", HostName())
+}
@@ -1,6 +1,7 @@
 package dnssd
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"net"
@@ -15,7 +16,13 @@ type netserver struct {
 	ipv4pconn *ipv4.PacketConn
 	ipv6pconn *ipv6.PacketConn
 
-	response, query *dns.Msg
+	// Records and questions scheduled to be sent.
+	responses    []*pendingRecord
+	additionals  []*pendingRecord
+	questions    []*pendingQuestion
+	knownAnswers []*pendingRecord
+	probeRecords []*pendingRecord
+	followUps    []*pendingMessage
 
 	closed    bool
 	msgCh     chan *incomingMsg
@@ -54,6 +61,23 @@ var (
 	}
 )
 
+// The top bit of the class in a response record is the cache-flush bit,
+// set on unique records to tell other hosts to flush older data.
+const cacheFlushBit = 0x8000
+
+const (
+	// The MTU assumed when the interface is unknown.
+	defaultMTU = 1500
+	// The largest mDNS message including IP and UDP headers, RFC6762 section 17.
+	maxMessageSize = 9000
+	// IPv6 and UDP headers, the larger of IPv4 and IPv6.
+	ipUDPHeaderSize = 48
+)
+
+// The top bit of the class in a question is the unicast-response (QU) bit,
+// the querier wants a unicast response, RFC6762 section 5.4.
+const unicastResponseBit = 0x8000
+
 func (im *incomingMsg) String() string {
 	return fmt.Sprintf("IM{%d,%s,%s}", im.ifIndex, im.from, im.msg)
 }
@@ -109,8 +133,7 @@ func makeNetserver() (*netserver, error) {
 	}
 
 	msgCh := make(chan *incomingMsg, 32)
-	ns := &netserver{ipv4pconn: p1, ipv6pconn: p2, msgCh: msgCh,
-		response: &dns.Msg{}, query: &dns.Msg{}}
+	ns := &netserver{ipv4pconn: p1, ipv6pconn: p2, msgCh: msgCh}
 	ns.startReceiving()
 	return ns, nil
 }
@@ -173,68 +196,88 @@ func (nss *netserver) shutdown() error {
 	return nil
 }
 
-func (nss *netserver) sendResponseRecord(ifIndex int, rr dns.RR) {
-	nss.response.Answer = appendRecord(nss.response.Answer, rr, "Response Record=")
-}
-
-func (nss *netserver) sendKnownAnswer(ifIndex int, rr dns.RR) {
-	nss.query.Answer = appendRecord(nss.query.Answer, rr, "Known Answer=")
-}
-
-func (nss *netserver) sendQuestion(ifIndex int, q *dns.Question) {
-	nss.query.Question = appendQuestion(nss.query.Question, q, "Question=")
-}
-
-func appendQuestion(qs []dns.Question, q *dns.Question, ref string) []dns.Question {
-	for _, tq := range qs {
-		if matchQuestions(&tq, q) {
-			return qs
-		}
+// Pack the dns.Msg and multicast it on an interface.
+func (nss *netserver) sendMessage(ifIndex int, msg *dns.Msg) error {
+	netlog.Debug.Println("TX: ifIndex=", ifIndex, msg)
+	buf, err := msg.Pack()
+	if err != nil {
+		log.Println("Failed to pack message!", err)
+		log.Println("Failed to pack message!", msg)
+		return err
+	}
+	if nss.ipv4pconn != nil {
+		nss.ipv4pconn.WriteTo(buf, &ipv4.ControlMessage{IfIndex: ifIndex}, ipv4Addr)
 	}
-	qlog.Debug.Println(ref, q.String())
-	return append(qs, *q)
+	if nss.ipv6pconn != nil {
+		nss.ipv6pconn.WriteTo(buf, &ipv6.ControlMessage{IfIndex: ifIndex}, ipv6Addr)
+	}
+	return nil
 }
 
-func appendRecord(rs []dns.RR, rr dns.RR, ref string) []dns.RR {
-	for _, trr := range rs {
-		if matchRRs(trr, rr) {
-			return rs
+// Pack the dns.Msg and write it directly to a host reached on an interface.
+func (nss *netserver) sendUnicastMessage(ifIndex int, msg *dns.Msg, to net.Addr) error {
+	netlog.Debug.Println("TX: ifIndex=", ifIndex, ", to=", to, msg)
+	buf, err := msg.Pack()
+	if err != nil {
+		log.Println("Failed to pack message!", err)
+		log.Println("Failed to pack message!", msg)
+		return err
+	}
+	if ua, ok := to.(*net.UDPAddr); ok && ua.IP.To4() == nil {
+		if nss.ipv6pconn != nil {
+			nss.ipv6pconn.WriteTo(buf, &ipv6.ControlMessage{IfIndex: ifIndex}, to)
 		}
+	} else if nss.ipv4pconn != nil {
+		nss.ipv4pconn.WriteTo(buf, &ipv4.ControlMessage{IfIndex: ifIndex}, to)
 	}
-	qlog.Debug.Println(ref, rr)
-	return append(rs, rr)
-}
-
-func (nss *netserver) sendPending() error {
-	nss.sendMessage(&nss.response)
-	nss.sendMessage(&nss.query)
 	return nil
 }
 
-// Pack the dns.Msg and write to available connections (multicast)
-func (nss *netserver) sendMessage(msgp **dns.Msg) error {
-	msg := *msgp
-	if len(msg.Answer) == 0 && len(msg.Question) == 0 {
+// All interfaces that are up and can multicast.
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		netlog.Info.Println("[ERR] dnssd: Failed to list interfaces: ", err)
 		return nil
 	}
-	newMsg := &dns.Msg{}
-	*msgp = newMsg
-	newMsg.Response = (msgp == &nss.response)
+	var mifaces []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+			mifaces = append(mifaces, iface)
+		}
+	}
+	return mifaces
+}
 
-	netlog.Debug.Println("TX:", msg)
-	buf, err := msg.Pack()
-	if err != nil {
-		log.Println("Failed to pack message!", err)
-		log.Println("Failed to pack message!", *msgp)
-		return err
+// A description of the multicast interfaces and their addresses used
+// to detect network topology changes.
+func interfaceTopology() string {
+	var b bytes.Buffer
+	for _, iface := range multicastInterfaces() {
+		fmt.Fprint(&b, iface.Index, ":", iface.Name, "=")
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				fmt.Fprint(&b, addr, ",")
+			}
+		}
+		b.WriteString(";")
 	}
-	if nss.ipv4pconn != nil {
-		nss.ipv4pconn.WriteTo(buf, nil, ipv4Addr)
+	return b.String()
+}
+
+// The largest DNS message that can be sent on an interface without
+// fragmentation.
+func packetSize(ifIndex int) int {
+	mtu := defaultMTU
+	if ifIndex > 0 {
+		if iface, err := net.InterfaceByIndex(ifIndex); err == nil && iface.MTU > 0 {
+			mtu = iface.MTU
+		}
 	}
-	if nss.ipv6pconn != nil {
-		nss.ipv6pconn.WriteTo(buf, nil, ipv6Addr)
+	if mtu > maxMessageSize {
+		mtu = maxMessageSize
 	}
-	return nil
+	return mtu - ipUDPHeaderSize
 }
 
 func isFromLocalHost(ifIndex int, faddr net.Addr) bool {
@@ -10,7 +10,7 @@ import (
 func makeTestNetserver() (ns *netserver, err error) {
 	msgCh := make(chan *incomingMsg, 32)
 
-	ns = &netserver{response: &dns.Msg{}, query: &dns.Msg{}}
+	ns = &netserver{}
 	ns.msgCh = msgCh
 
 	return
@@ -28,7 +28,7 @@ func fakeIncomingMsg(response bool) *incomingMsg {
 
 func (im *incomingMsg) addRR(name string, rrtype uint16, args ...interface{}) *incomingMsg {
 	var rr dns.RR
-	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Rrtype: rrtype}
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Rrtype: rrtype, Ttl: 120}
 	switch rrtype {
 	case dns.TypeA:
 		ip, err := net.ResolveIPAddr("ip4", args[0].(string))
@@ -56,9 +56,51 @@ func (im *incomingMsg) addRR(name string, rrtype uint16, args ...interface{}) *i
 			txt[ii] = v.(string)
 		}
 		rr = &dns.TXT{Hdr: hdr, Txt: txt}
+	case dns.TypeNSEC:
+		types := make([]uint16, len(args))
+		for ii, v := range args {
+			types[ii] = v.(uint16)
+		}
+		rr = &dns.NSEC{Hdr: hdr, NextDomain: name, TypeBitMap: types}
 	default:
 		panic(fmt.Sprint("Cant make RR of type ", rrtype))
 	}
 	im.msg.Answer = append(im.msg.Answer, rr)
 	return im
 }
+
+// Set the TTL of the last added RR
+func (im *incomingMsg) ttl(ttl uint32) *incomingMsg {
+	im.msg.Answer[len(im.msg.Answer)-1].Header().Ttl = ttl
+	return im
+}
+
+// Set the cache-flush bit on the last added RR
+func (im *incomingMsg) flush() *incomingMsg {
+	im.msg.Answer[len(im.msg.Answer)-1].Header().Class |= cacheFlushBit
+	return im
+}
+
+func (nss *netserver) responseRecords() []dns.RR {
+	var rrs []dns.RR
+	for _, pr := range nss.responses {
+		rrs = append(rrs, pr.rr)
+	}
+	return rrs
+}
+
+func (nss *netserver) knownAnswerRecords() []dns.RR {
+	var rrs []dns.RR
+	for _, pr := range nss.knownAnswers {
+		rrs = append(rrs, pr.rr)
+	}
+	return rrs
+}
+
+func (nss *netserver) queryQuestions() []dns.Question {
+	var qs []dns.Question
+	for _, pq := range nss.questions {
+		qs = append(qs, pq.q)
+	}
+	return qs
+}
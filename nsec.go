@@ -0,0 +1,107 @@
+package dnssd
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Make an NSEC record asserting which types exist for a name we are
+// authoritative for on an interface, RFC6762 section 6.1. We are
+// authoritative for a name if we have published a unique record for it.
+// The restricted form is used, the next domain is the name itself and
+// only types below 256 are listed. Returns nil if we don't own the name.
+func (ds *dnssd) makeNSEC(ifIndex int, name string) dns.RR {
+	var types []uint16
+	var ttl uint32
+	owned := false
+	for _, a := range ds.rrl.cache {
+		h := a.rr.Header()
+		if (a.ifIndex != 0 && a.ifIndex != ifIndex) || !strings.EqualFold(h.Name, name) || h.Rrtype >= 256 {
+			continue
+		}
+		if a.flags&Unique != 0 {
+			owned = true
+		}
+		if ttl == 0 || h.Ttl < ttl {
+			ttl = h.Ttl
+		}
+		types = appendTypeIfMissing(types, h.Rrtype)
+	}
+	if !owned {
+		return nil
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	nsec := new(dns.NSEC)
+	nsec.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl}
+	nsec.NextDomain = name
+	nsec.TypeBitMap = types
+	return nsec
+}
+
+// The last time, possibly scheduled in the future, our NSEC record for
+// a name was multicast on an interface. Multicasts on all interfaces count.
+func (ds *dnssd) lastNSECMulticastOn(ifIndex int, name string) time.Time {
+	lm := ds.nsecMulticast[strings.ToLower(name)]
+	last := lm[ifIndex]
+	if all := lm[0]; all.After(last) {
+		last = all
+	}
+	return last
+}
+
+// Remember when our NSEC record for a name is multicast on an interface.
+func (ds *dnssd) nsecMulticastAt(ifIndex int, name string, at time.Time) {
+	if ds.nsecMulticast == nil {
+		ds.nsecMulticast = make(map[string]map[int]time.Time)
+	}
+	key := strings.ToLower(name)
+	if ds.nsecMulticast[key] == nil {
+		ds.nsecMulticast[key] = make(map[int]time.Time)
+	}
+	ds.nsecMulticast[key][ifIndex] = at
+}
+
+// Check if a record is an NSEC record telling that there is no record
+// of the type asked for. NSEC questions and ANY questions are answered
+// by the NSEC record itself.
+func isNegativeAnswer(q *dns.Question, rr dns.RR) bool {
+	nsec, ok := rr.(*dns.NSEC)
+	if !ok || q.Qtype == dns.TypeNSEC || q.Qtype == dns.TypeANY {
+		return false
+	}
+	for _, t := range nsec.TypeBitMap {
+		if t == q.Qtype {
+			return false
+		}
+	}
+	return true
+}
+
+// The NoSuchRecord flag if the record tells there is no answer to the question.
+func negativeFlag(q *dns.Question, rr dns.RR) Flags {
+	if isNegativeAnswer(q, rr) {
+		return NoSuchRecord
+	}
+	return None
+}
+
+func appendTypeIfMissing(types []uint16, t uint16) []uint16 {
+	for _, tt := range types {
+		if tt == t {
+			return types
+		}
+	}
+	return append(types, t)
+}
+
+func appendIfMissingName(names []string, name string) []string {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return names
+		}
+	}
+	return append(names, name)
+}
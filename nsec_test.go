@@ -0,0 +1,143 @@
+package dnssd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func (ds *dnssd) addTestHost(ifIndex int) {
+	ds.rrl.addRecord(nil, Unique, ifIndex, makeTestARecord("myhost.local.", "10.0.0.1"))
+}
+
+func TestMakeNSEC(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestService(2)
+	assert.Nil(t, ds.makeNSEC(2, "myhost.local."))
+
+	ds.addTestHost(2)
+	assert.Equal(t, "myhost.local.\t120\tIN\tNSEC\tmyhost.local. A", ds.makeNSEC(2, "myhost.local.").String())
+	assert.Nil(t, ds.makeNSEC(3, "myhost.local."))
+	assert.Nil(t, ds.makeNSEC(2, "_tuting._tcp.local."))
+}
+
+func TestNegativeResponse(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestHost(2)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{{Name: "myhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, "myhost.local.\t120\tCLASS32769\tNSEC\tmyhost.local. A", ds.ns.responses[0].rr.String())
+
+	// Not for names we don't own.
+	ds.ns.responses = nil
+	im.msg.Question = []dns.Question{{Name: "otherhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 0, len(ds.ns.responses))
+}
+
+func TestNegativeResponseRateLimit(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestHost(2)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{{Name: "myhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	first := ds.ns.responses[0].at
+	assert.Equal(t, first, ds.lastNSECMulticastOn(2, "myhost.local."))
+
+	// Not multicast again within a second.
+	ds.ns.responses = nil
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.False(t, ds.ns.responses[0].at.Before(first.Add(time.Second)))
+}
+
+func TestAdditionalNSEC(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	ds.addTestHost(2)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{{Name: "myhost.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}}
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.ns.responses))
+	assert.Equal(t, 1, len(ds.ns.additionals))
+	assert.Equal(t, dns.TypeNSEC, ds.ns.additionals[0].rr.Header().Rrtype)
+}
+
+func TestIsNegativeAnswer(t *testing.T) {
+	nsec := fakeIncomingMsg(true).addRR("myhost.local.", dns.TypeNSEC, dns.TypeA).msg.Answer[0]
+	q := &dns.Question{Name: "myhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+	assert.True(t, isNegativeAnswer(q, nsec))
+	assert.True(t, matchQuestionAndRR(q, nsec))
+	assert.Equal(t, NoSuchRecord, negativeFlag(q, nsec))
+
+	q.Qtype = dns.TypeA
+	assert.False(t, isNegativeAnswer(q, nsec))
+	assert.False(t, matchQuestionAndRR(q, nsec))
+
+	q.Qtype = dns.TypeNSEC
+	assert.False(t, isNegativeAnswer(q, nsec))
+	assert.True(t, matchQuestionAndRR(q, nsec))
+	assert.Equal(t, None, negativeFlag(q, nsec))
+
+	q.Name = "otherhost.local."
+	q.Qtype = dns.TypeAAAA
+	assert.False(t, matchQuestionAndRR(q, nsec))
+}
+
+func TestNegativeCaching(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := &dns.Question{Name: "myhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+	response := func(flags Flags, ifIndex int, rr dns.RR) {
+		rrc <- fmt.Sprint(flags, ": ", rr)
+	}
+
+	Query(ctx, 0, 0, q, response, nil)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("myhost.local.", dns.TypeNSEC, dns.TypeA).flush()
+	assertMessage(t, time.Second, "RecordAdded | NoSuchRecord: myhost.local.\t120\tIN\tNSEC\tmyhost.local. A", rrc)
+
+	// The next query is answered from the cache.
+	Query(ctx, 0, 0, q, response, nil)
+	assertMessage(t, time.Second, "RecordAdded | NoSuchRecord: myhost.local.\t120\tIN\tNSEC\tmyhost.local. A", rrc)
+}
+
+func TestNegativeCacheInvalidation(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := &dns.Question{Name: "myhost.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+	response := func(flags Flags, ifIndex int, rr dns.RR) {
+		rrc <- fmt.Sprint(flags, ": ", rr)
+	}
+
+	Query(ctx, 0, 0, q, response, nil)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("myhost.local.", dns.TypeNSEC, dns.TypeA).flush()
+	assertMessage(t, time.Second, "RecordAdded | NoSuchRecord: myhost.local.\t120\tIN\tNSEC\tmyhost.local. A", rrc)
+
+	// An AAAA record shows the NSEC record is out of date.
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("myhost.local.", dns.TypeAAAA, "fe80::1").flush()
+	assertMessage(t, time.Second, "MoreComing | NoSuchRecord: myhost.local.\t120\tIN\tNSEC\tmyhost.local. A", rrc)
+	assertMessage(t, time.Second, "RecordAdded: myhost.local.\t120\tIN\tAAAA\tfe80::1", rrc)
+
+	// The next query is answered with the AAAA record only.
+	Query(ctx, 0, 0, q, response, nil)
+	assertMessage(t, time.Second, "RecordAdded: myhost.local.\t120\tIN\tAAAA\tfe80::1", rrc)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, len(rrc))
+}
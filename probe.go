@@ -0,0 +1,154 @@
+package dnssd
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// A probe is a unique record which we are currently probing for
+// before announcing it. If we lose a simultaneous probe tiebreak
+// the prober is notified on the lost channel.
+type probe struct {
+	ifIndex int
+	rr      dns.RR
+	lost    chan struct{}
+}
+
+// A collection of running probes.
+type probes struct {
+	list []*probe
+}
+
+func makeProbe(ifIndex int, rr dns.RR) *probe {
+	return &probe{ifIndex, rr, make(chan struct{}, 1)}
+}
+
+func (ps *probes) add(p *probe) {
+	ps.list = append(ps.list, p)
+}
+
+func (ps *probes) remove(p *probe) {
+	jj := 0
+	for _, pa := range ps.list {
+		if pa != p {
+			ps.list[jj] = pa
+			jj++
+		}
+	}
+	ps.list = ps.list[0:jj]
+}
+
+// Return the records of all running probes for a name.
+func (ps *probes) records(name string) []dns.RR {
+	var rrs []dns.RR
+	for _, p := range ps.list {
+		if p.rr.Header().Name == name {
+			rrs = append(rrs, p.rr)
+		}
+	}
+	return rrs
+}
+
+// Notify all running probes for a name that they have lost
+// the tiebreak.
+func (ps *probes) lose(name string) {
+	for _, p := range ps.list {
+		if p.rr.Header().Name == name {
+			select {
+			case p.lost <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+/*
+A query with records in the authority section is a probe. If we are
+probing for the same name we have a simultaneous probe and the tie is
+broken by comparing the records of each host. The host with the
+lexicographically later data wins, the loser has to defer and probe
+again, see RFC6762 section 8.2.
+*/
+func (ds *dnssd) handleIncomingProbe(im *incomingMsg) {
+	for _, q := range im.msg.Question {
+		ours := ds.ps.records(q.Name)
+		if len(ours) == 0 {
+			continue
+		}
+		var theirs []dns.RR
+		for _, rr := range im.msg.Ns {
+			if rr.Header().Name == q.Name {
+				theirs = append(theirs, rr)
+			}
+		}
+		if compareRecordSets(ours, theirs) < 0 {
+			dnssdlog.Info.Println("Lost probe tiebreak for", q.Name, "from", im.from)
+			ds.ps.lose(q.Name)
+		}
+	}
+}
+
+// Compare two sets of records by sorting them and then comparing
+// them pairwise. If one set runs out of records first it is
+// considered lexicographically earlier.
+// Returns -1, 0 or 1 if rrs1 is earlier, equal or later than rrs2.
+func compareRecordSets(rrs1, rrs2 []dns.RR) int {
+	s1 := sortedRecords(rrs1)
+	s2 := sortedRecords(rrs2)
+	for ii := 0; ii < len(s1) && ii < len(s2); ii++ {
+		if c := compareRecords(s1[ii], s2[ii]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(s1) < len(s2):
+		return -1
+	case len(s1) > len(s2):
+		return 1
+	}
+	return 0
+}
+
+func sortedRecords(rrs []dns.RR) []dns.RR {
+	s := make([]dns.RR, len(rrs))
+	copy(s, rrs)
+	sort.Slice(s, func(i, j int) bool {
+		return compareRecords(s[i], s[j]) < 0
+	})
+	return s
+}
+
+// Compare two records by class (excluding the cache-flush bit), type
+// and raw rdata as described in RFC6762 section 8.2.
+// Returns -1, 0 or 1 if rr1 is earlier, equal or later than rr2.
+func compareRecords(rr1, rr2 dns.RR) int {
+	h1, h2 := rr1.Header(), rr2.Header()
+	c1, c2 := h1.Class&^cacheFlushBit, h2.Class&^cacheFlushBit
+	switch {
+	case c1 < c2:
+		return -1
+	case c1 > c2:
+		return 1
+	case h1.Rrtype < h2.Rrtype:
+		return -1
+	case h1.Rrtype > h2.Rrtype:
+		return 1
+	}
+	return bytes.Compare(rdata(rr1), rdata(rr2))
+}
+
+// Return the uncompressed wire format rdata of a record.
+func rdata(rr dns.RR) []byte {
+	// Pack with the root name so the header is always 11 bytes.
+	c := dns.Copy(rr)
+	c.Header().Name = "."
+	buf := make([]byte, dns.MaxMsgSize)
+	off, err := dns.PackRR(c, buf, 0, nil, false)
+	if err != nil {
+		dnssdlog.Info.Println("Failed to pack record", rr, err)
+		return nil
+	}
+	return buf[11:off]
+}
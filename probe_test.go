@@ -0,0 +1,85 @@
+package dnssd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestARecord(name, ip string) dns.RR {
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120}
+	rr.A = net.ParseIP(ip)
+	return rr
+}
+
+// Turn the answers of an incoming message into a probe for name.
+func (im *incomingMsg) asProbe(name string) *incomingMsg {
+	im.msg.Question = append(im.msg.Question, dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+	im.msg.Ns = im.msg.Answer
+	im.msg.Answer = nil
+	return im
+}
+
+func TestCompareRecords(t *testing.T) {
+	a1 := makeTestARecord("tuting.local.", "169.254.99.200")
+	a2 := makeTestARecord("tuting.local.", "169.254.200.50")
+	assert.Equal(t, -1, compareRecords(a1, a2))
+	assert.Equal(t, 1, compareRecords(a2, a1))
+	assert.Equal(t, 0, compareRecords(a1, a1))
+
+	// The cache-flush bit is not compared.
+	a3 := makeTestARecord("tuting.local.", "169.254.99.200")
+	a3.Header().Class |= cacheFlushBit
+	assert.Equal(t, 0, compareRecords(a1, a3))
+
+	// Type is compared before rdata
+	p1 := makeTestPtrAnswer(2, "tuting.local.", "a", 120)
+	assert.Equal(t, -1, compareRecords(a2, p1.rr))
+}
+
+func TestCompareRecordSets(t *testing.T) {
+	a1 := makeTestARecord("tuting.local.", "169.254.99.200")
+	a2 := makeTestARecord("tuting.local.", "169.254.200.50")
+
+	assert.Equal(t, 0, compareRecordSets([]dns.RR{a1, a2}, []dns.RR{a2, a1}))
+	assert.Equal(t, -1, compareRecordSets([]dns.RR{a1}, []dns.RR{a1, a2}))
+	assert.Equal(t, 1, compareRecordSets([]dns.RR{a2}, []dns.RR{a1, a2}))
+}
+
+func TestProbeTiebreak(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+
+	p := makeProbe(2, makeTestARecord("tuting.local.", "169.254.99.200"))
+	ds.ps.add(p)
+
+	// Our data is later, we win.
+	ds.handleIncomingMessage(fakeIncomingMsg(false).addRR("tuting.local.", dns.TypeA, "169.254.10.10").asProbe("tuting.local."))
+	assertNotLost(t, p)
+
+	// Identical data is not a conflict
+	ds.handleIncomingMessage(fakeIncomingMsg(false).addRR("tuting.local.", dns.TypeA, "169.254.99.200").asProbe("tuting.local."))
+	assertNotLost(t, p)
+
+	// Their data is later, we lose.
+	ds.handleIncomingMessage(fakeIncomingMsg(false).addRR("tuting.local.", dns.TypeA, "169.254.200.50").asProbe("tuting.local."))
+	select {
+	case <-p.lost:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Probe did not lose the tiebreak")
+	}
+
+	ds.ps.remove(p)
+	assert.Nil(t, ds.ps.records("tuting.local."))
+}
+
+func assertNotLost(t *testing.T, p *probe) {
+	select {
+	case <-p.lost:
+		assert.Fail(t, "Probe lost the tiebreak")
+	default:
+	}
+}
@@ -10,7 +10,8 @@ const MORE_COMING = 1
 const RECORD_ADDED = 8
 
 /* This is called when a query has been resolved.
-flags may be MORE_COMING or RECORD_ADDED.
+flags may be MORE_COMING or RECORD_ADDED. NoSuchRecord is set if rr is an NSEC record from a host
+telling that the record asked for doesn't exist.
 ifIndex is the interface the query was responden on.
 rr is a resource record matching the query.
 */
@@ -25,13 +26,14 @@ func query(ctx context.Context, flags Flags, ifIndex int, question *dns.Question
 	cb := makeCallback("query", question, ctx, ifIndex, response)
 	ds.cmdCh <- func() {
 		dnssdlog.Info.Println("DNSSD QUESTION=", question)
-		ds.runQuery(ifIndex, question, cb)
+		ds.runQuery(flags, ifIndex, question, cb)
 	}
 }
 
 /*
 Query an arbitrary record. ctx is the query context and can be used to cancel or timeout a query.
-flags - Possible values are: MORE_COMING.
+flags - Possible values are: MORE_COMING and UnicastResponse. With UnicastResponse the first question is
+sent with the QU bit set asking responders to reply directly to us.
 ifIndex - If non-zero, specifies the interface on which to issue the query (the index for a given interface is determined via the if_nametoindex() family of calls.) Passing 0 causes the name to be queried for on all interfaces. Passing -1 causes the name to be queried for only on the local host.
 question - The question to query for.
 response - This closure will get called when the query completes.
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -30,9 +31,69 @@ func TestQuery1(t *testing.T) {
 		}, errc)
 
 	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("turner.local.", dns.TypeA, "10.20.30.40")
-	assert.Equal(t, "turner.local.\t0\tIN\tA\t10.20.30.40", <-rrc)
+	assert.Equal(t, "turner.local.\t120\tIN\tA\t10.20.30.40", <-rrc)
 
-	assert.Equal(t, 1, len(ds.ns.query.Question))
-	assert.Equal(t, ";turner.local.\tIN\t A", ds.ns.query.Question[0].String())
+	assert.Equal(t, 1, len(ds.ns.queryQuestions()))
+	assert.Equal(t, ";turner.local.\tIN\t A", ds.ns.queryQuestions()[0].String())
 
 }
+
+func TestQueryGoodbye(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Query(ctx, 0, 0, &dns.Question{Name: "turner.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		func(flags Flags, ifIndex int, rr dns.RR) {
+			rrc <- fmt.Sprint(flags, ":", rr)
+		}, nil)
+
+	// A goodbye for an unknown record is ignored
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("turner.local.", dns.TypeA, "10.20.30.41").ttl(0)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("turner.local.", dns.TypeA, "10.20.30.40")
+	assert.Equal(t, "RecordAdded:turner.local.\t120\tIN\tA\t10.20.30.40", <-rrc)
+
+	start := time.Now()
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("turner.local.", dns.TypeA, "10.20.30.40").ttl(0)
+	assertMessage(t, 2*time.Second, "None:turner.local.\t120\tIN\tA\t10.20.30.40", rrc)
+	assert.True(t, time.Since(start) >= time.Second)
+	assert.Equal(t, 0, ds.rrc.size())
+}
+
+func TestQueryCacheFlush(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Query(ctx, 0, 0, &dns.Question{Name: "rafael._airplay._tcp.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET},
+		func(flags Flags, ifIndex int, rr dns.RR) {
+			rrc <- fmt.Sprint(flags, ":", rr)
+		}, nil)
+
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("rafael._airplay._tcp.local.", dns.TypeSRV, 0, 0, 4711, "rafael.local.").flush()
+	assert.Equal(t, "RecordAdded:rafael._airplay._tcp.local.\t120\tIN\tSRV\t0 0 4711 rafael.local.", <-rrc)
+
+	// Records in the rrset received within the last second are kept
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("rafael._airplay._tcp.local.", dns.TypeSRV, 0, 0, 4712, "rafael.local.").flush()
+	assert.Equal(t, "RecordAdded:rafael._airplay._tcp.local.\t120\tIN\tSRV\t0 0 4712 rafael.local.", <-rrc)
+
+	ds.cmdCh <- func() {
+		for _, a := range ds.rrc.cache {
+			a.added = a.added.Add(-2 * time.Second)
+		}
+	}
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("rafael._airplay._tcp.local.", dns.TypeSRV, 0, 0, 4713, "rafael.local.").flush()
+	assert.Equal(t, "RecordAdded:rafael._airplay._tcp.local.\t120\tIN\tSRV\t0 0 4713 rafael.local.", <-rrc)
+	assertMessage(t, 2*time.Second, "MoreComing:rafael._airplay._tcp.local.\t120\tIN\tSRV\t0 0 4711 rafael.local.", rrc)
+	assertMessage(t, 2*time.Second, "None:rafael._airplay._tcp.local.\t120\tIN\tSRV\t0 0 4712 rafael.local.", rrc)
+}
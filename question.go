@@ -2,6 +2,7 @@ package dnssd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -14,6 +15,12 @@ import (
 type question struct {
 	q  *dns.Question
 	cb []*callback
+
+	// Continuous querying, RFC6762 section 5.2.
+	ifIndex  int
+	unicast  bool          // Ask for unicast responses in the next query.
+	interval time.Duration // Time until the query after the next, zero if not queried, e.g. probes.
+	next     time.Time     // When the next query is sent.
 }
 
 // A collection of questions.
@@ -62,9 +69,18 @@ func (cq *question) detach(cb *callback) {
 // Send an RR to all attached callbacks. If a callback
 // returns false it will be removed as callback.
 func (cq *question) respond(a *answer) {
+	cq.deliver(a, false, None)
+}
+
+// Tell all attached callbacks that an RR has been removed.
+func (cq *question) remove(a *answer) {
+	cq.deliver(a, true, None)
+}
+
+func (cq *question) deliver(a *answer, removed bool, more Flags) {
 	jj := 0
 	for _, cba := range cq.cb {
-		if cba.respond(a) {
+		if cq.deliverTo(cba, a, removed, more) {
 			cq.cb[jj] = cba
 			jj++
 		}
@@ -72,6 +88,12 @@ func (cq *question) respond(a *answer) {
 	cq.cb = cq.cb[0:jj]
 }
 
+// Deliver an answer to one callback, NoSuchRecord is set if the answer
+// is an NSEC record telling there is no record for the question.
+func (cq *question) deliverTo(cb *callback, a *answer, removed bool, more Flags) bool {
+	return cb.deliver(a, removed, more|negativeFlag(cq.q, a.rr))
+}
+
 // Check on callbacks and return true if any callback
 // is still active.
 func (cq *question) isActive() bool {
@@ -86,12 +108,60 @@ func (cq *question) isActive() bool {
 	return jj > 0
 }
 
+// A batch of answers and removals from the same packet or cache
+// check. The callbacks of a question get MoreComing set on all but
+// the last change for that question.
+type answerBatch struct {
+	changes []answerChange
+}
+
+type answerChange struct {
+	cq      *question
+	a       *answer
+	removed bool
+}
+
+func (b *answerBatch) respond(cq *question, a *answer) {
+	b.changes = append(b.changes, answerChange{cq, a, false})
+}
+
+func (b *answerBatch) remove(cq *question, a *answer) {
+	b.changes = append(b.changes, answerChange{cq, a, true})
+}
+
+// Deliver all changes in the batch to the callbacks.
+func (b *answerBatch) deliver() {
+	for ii, c := range b.changes {
+		more := None
+		for _, lc := range b.changes[ii+1:] {
+			if lc.cq == c.cq {
+				more = MoreComing
+				break
+			}
+		}
+		c.cq.deliver(c.a, c.removed, more)
+	}
+	b.changes = nil
+}
+
 func (qs *questions) makeQuestion(q *dns.Question) *question {
-	cq := &question{q, nil}
+	cq := &question{q: q}
 	qs.qmap = append(qs.qmap, cq)
 	return cq
 }
 
+// Remove questions without any active callbacks.
+func (qs *questions) removeInactive() {
+	jj := 0
+	for _, cq := range qs.qmap {
+		if cq.isActive() {
+			qs.qmap[jj] = cq
+			jj++
+		}
+	}
+	qs.qmap = qs.qmap[0:jj]
+}
+
 // Find the DNSSD question registered from the dns.Question.
 func (qs *questions) findQuestion(q *dns.Question) *question {
 	for _, cq := range qs.qmap {
@@ -111,6 +181,17 @@ func (qs *questions) findQuestionFromRR(rr dns.RR) *question {
 	return nil
 }
 
+// Find all DNSSD questions answered by a record.
+func (qs *questions) findQuestionsFromRR(rr dns.RR) []*question {
+	var cqs []*question
+	for _, cq := range qs.qmap {
+		if matchQuestionAndRR(cq.q, rr) {
+			cqs = append(cqs, cq)
+		}
+	}
+	return cqs
+}
+
 func questionFromRRHeader(rrh *dns.RR_Header) *dns.Question {
 	return &dns.Question{Name: rrh.Name, Qtype: rrh.Rrtype, Qclass: rrh.Class}
 }
@@ -3,8 +3,7 @@ package dnssd
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"os"
+	"sync"
 
 	"github.com/miekg/dns"
 )
@@ -12,7 +11,8 @@ import (
 /*
 Called when a service has been registered. Flags are currently unused and always 0, serviceName
 is the name registered. It  may have been automatically chosen if the name was blank in the call to
-Register. The regType is the same as passed to Register. The domain parameter is the name of the
+Register or renamed, e.g. "Printer (2)", if the name was already in use on the network. The
+regType is the same as passed to Register. The domain parameter is the name of the
 domain the service was registered to, will be the default domain if domain was blank in the call
 to Register
 */
@@ -20,23 +20,28 @@ type ServiceRegistered func(flags int, serviceName, regType, domain string)
 
 /*
 Add an additional record to the service registration. This will be registered
-using the same context as the service was registered with*/
+using the same context as the service was registered with. A TXT record replaces the
+data of the TXT record of the service which is then announced again with the new data.*/
 type AddRecord func(flags int, rr dns.RR)
 
 /*
 Register a service. ctx is the context and is used to cancel a registration.
 ifIndex is the interface to publish the service on, 0 for all interfaces and -1 for localhost.
 serviceName is the name of the service. if left blank the computer name will be used and
-propagated to the ServiceRegistered callback. flags can be 0 or set to NoAutoRename. regType is
+propagated to the ServiceRegistered callback. flags can be 0 or set to NoAutoRename. If the
+name is in use by another host the service is renamed "<serviceName> (2)", "<serviceName> (3)", ...
+and all records are registered again under the new name. With NoAutoRename a *ConflictError
+is passed to errc instead, NoAutoRename requires an explicit serviceName. regType is
 the service registration type.
 domain is the domain of the service, usually left blank.
-host is the name of the server being registered. usually left blank for the local machine name.
+host is the name of the server being registered. usually left blank for the local machine name
+as published by the library, see HostName. The SRV record then follows renames of the host.
 port is the port of the service.
 txtRecord is the content of the TXT record.
 listener is a closure that will be called when the service has been registered.
 errc is a closure that will be called if there was an error registering the service.
 The return from the func is an AddRecord func that can be called to add additional records
-that will be associated with this service.
+that will be associated with this service or to update its TXT record.
 */
 func Register(ctx context.Context, flags Flags, ifIndex int, serviceName, regType, domain, host string, port uint16, txt []string,
 	listener ServiceRegistered, errc ErrCallback) AddRecord {
@@ -50,60 +55,144 @@ func Register(ctx context.Context, flags Flags, ifIndex int, serviceName, regTyp
 		domain = getOwnDomainname()
 	}
 
-	if host == "" {
-		h, err := os.Hostname()
-		if err != nil {
-			errc(err)
-			return nil
-		}
-		host = h
+	followHost := host == ""
+	if followHost {
+		host = getHostLabel()
 	}
 	if serviceName == "" {
+		if flags&NoAutoRename != 0 {
+			errc(errBadFlags)
+			return nil
+		}
 		serviceName = getManufacturedServiceName(host)
 	}
 
 	fullRegType := fmt.Sprintf("%s.%s.", regType, domain)
-	fullName := ConstructFullName(serviceName, regType, domain)
 	target := fmt.Sprintf("%s.%s.", host, domain)
 
-	recordsRegistered := uint8(0)
-	var registrar RegisterRecord
-	registrar = CreateRecordRegistrar(func(record dns.RR, flags int) {
-		fmt.Println("REGISTER: rr=", record)
-		recordsRegistered = recordsRegistered | flag(record)
-		if recordsRegistered == 6 {
-			// TXT and SRV are established. send the PTR
-			ptrRR := new(dns.PTR)
-			ptrRR.Hdr = dns.RR_Header{Name: fullRegType, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 3200} // TODO: TTL correct?
-			ptrRR.Ptr = fullName
-			fmt.Println("ptrRR=", ptrRR)
-			registrar(ctx, Shared, ifIndex, ptrRR)
+	// The TXT record of the service may be updated through AddRecord.
+	var txtLock sync.Mutex
+	var txtRegistered *dns.TXT
+	// Publish the current TXT data if it differs from the registered
+	// TXT record. Must be called with txtLock held.
+	updateTXT := func() {
+		if txtRegistered == nil {
+			return
+		}
+		updated := dns.Copy(txtRegistered).(*dns.TXT)
+		updated.Txt = txt
+		if matchRRData(updated, txtRegistered) {
+			return
 		}
-		if recordsRegistered == 7 {
-			listener(0, serviceName, regType, domain)
+		old := txtRegistered
+		txtRegistered = updated
+		dnssdlog.Info.Println("DNSSD UPDATE=", updated)
+		ds.cmdCh <- func() {
+			ds.update(ifIndex, old, updated)
 		}
-	}, func(err error) {
-		errc(err)
-	})
-
-	srvRR := new(dns.SRV)
-	srvRR.Hdr = dns.RR_Header{Name: fullName, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 20} // TODO: TTL correct?
-	srvRR.Target = target
-	srvRR.Port = port
-	srvRR.Priority = 0 // TODO: correct?
-	srvRR.Weight = 0   // TODO: correct?
-	fmt.Println("srvRR=", srvRR)
-	registrar(ctx, Unique, ifIndex, srvRR)
-
-	if txt != nil {
-		txtRR := new(dns.TXT)
-		txtRR.Hdr = dns.RR_Header{Name: fullName, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 3200} // TODO: TTL correct?
-		txtRR.Txt = txt
-		fmt.Println("txtRR=", txtRR)
-		registrar(ctx, Unique, ifIndex, txtRR)
 	}
 
+	var registerService func(serviceName string)
+	registerService = func(serviceName string) {
+		// Each name gets its own context so the records can be
+		// withdrawn if we have to rename the service.
+		sctx, cancel := context.WithCancel(ctx)
+		fullName := ConstructFullName(serviceName, regType, domain)
+
+		var lock sync.Mutex
+		var renameOnce, registeredOnce sync.Once
+		recordsRegistered := uint8(0)
+		var registrar RegisterRecord
+		registrar = CreateRecordRegistrar(func(record dns.RR, flags int) {
+			fmt.Println("REGISTER: rr=", record)
+			lock.Lock()
+			recordsRegistered = recordsRegistered | flag(record)
+			rs := recordsRegistered
+			lock.Unlock()
+			if txtRR, ok := record.(*dns.TXT); ok {
+				// The TXT data may have been updated while probing.
+				txtLock.Lock()
+				txtRegistered = txtRR
+				updateTXT()
+				txtLock.Unlock()
+			}
+			if rs == 6 {
+				// TXT and SRV are established. send the PTR
+				ptrRR := new(dns.PTR)
+				ptrRR.Hdr = dns.RR_Header{Name: fullRegType, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 3200} // TODO: TTL correct?
+				ptrRR.Ptr = fullName
+				fmt.Println("ptrRR=", ptrRR)
+				registrar(sctx, Shared, ifIndex, ptrRR)
+			}
+			if rs == 7 {
+				// The SRV record is registered again if the host is renamed.
+				registeredOnce.Do(func() {
+					listener(0, serviceName, regType, domain)
+				})
+			}
+		}, func(err error) {
+			if _, ok := err.(*ConflictError); !ok || flags&NoAutoRename != 0 {
+				errc(err)
+				return
+			}
+			// The SRV and TXT records may both be in conflict, only rename once.
+			renameOnce.Do(func() {
+				cancel()
+				name := alternativeServiceName(serviceName)
+				dnssdlog.Info.Println("DNSSD RENAME SERVICE=", serviceName, "-->", name)
+				registerService(name)
+			})
+		})
+
+		// The service records are always renamed together so the
+		// registrar must not rename them individually. The SRV record
+		// has its own context so it can be withdrawn if the host is renamed.
+		registerSRV := func(target string) context.CancelFunc {
+			srvCtx, srvCancel := context.WithCancel(sctx)
+			srvRR := new(dns.SRV)
+			srvRR.Hdr = dns.RR_Header{Name: fullName, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 20} // TODO: TTL correct?
+			srvRR.Target = target
+			srvRR.Port = port
+			srvRR.Priority = 0 // TODO: correct?
+			srvRR.Weight = 0   // TODO: correct?
+			fmt.Println("srvRR=", srvRR)
+			registrar(srvCtx, Unique|NoAutoRename, ifIndex, srvRR)
+			return srvCancel
+		}
+		if followHost {
+			srvCancel := registerSRV(HostName())
+			onHostRename(sctx, func(name string) {
+				lock.Lock()
+				defer lock.Unlock()
+				dnssdlog.Info.Println("DNSSD SRV TARGET=", name)
+				srvCancel()
+				srvCancel = registerSRV(name)
+			})
+		} else {
+			registerSRV(target)
+		}
+
+		txtLock.Lock()
+		txt := txt
+		txtLock.Unlock()
+		if txt != nil {
+			txtRR := new(dns.TXT)
+			txtRR.Hdr = dns.RR_Header{Name: fullName, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 3200} // TODO: TTL correct?
+			txtRR.Txt = txt
+			fmt.Println("txtRR=", txtRR)
+			registrar(sctx, Unique|NoAutoRename, ifIndex, txtRR)
+		}
+	}
+	registerService(serviceName)
+
 	return func(flags int, rr dns.RR) {
+		if txtRR, ok := rr.(*dns.TXT); ok {
+			txtLock.Lock()
+			defer txtLock.Unlock()
+			txt = txtRR.Txt
+			updateTXT()
+			return
+		}
 		header := rr.Header()
 		if header.Name == "" {
 			header.Name = serviceName
@@ -116,9 +205,10 @@ func Register(ctx context.Context, flags Flags, ifIndex int, serviceName, regTyp
 	}
 }
 
+// A blank service name is replaced by the name of the host.
 func getManufacturedServiceName(hostname string) string {
-	// TODO: make a bit better.
-	return fmt.Sprintf("%s%x%x", hostname, rand.Uint32(), rand.Uint32())
+	label, _ := splitFirstLabel(hostname)
+	return label
 }
 
 func flag(rr dns.RR) uint8 {
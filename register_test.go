@@ -38,13 +38,149 @@ func TestRegister1(t *testing.T) {
 
 	assertMessage(t, time.Second, "Register: serviceName=Stryfnake, regType=_tuting._tcp,domain=local", rrc)
 	time.Sleep(1 * time.Millisecond)
-	assert.Equal(t, 3, len(ds.ns.response.Answer))
-	assertResponse(t, "Stryfnake._tuting._tcp.local.\t20\tIN\tSRV\t0 0 4711 myhost.local.", ds.ns.response.Answer)
-	assertResponse(t, "Stryfnake._tuting._tcp.local.\t3200\tIN\tTXT\t\"test=hej\" \"tjo=hopp\"", ds.ns.response.Answer)
-	assertResponse(t, "_tuting._tcp.local.\t3200\tIN\tPTR\tStryfnake._tuting._tcp.local.", ds.ns.response.Answer)
+	assert.Equal(t, 3, len(ds.ns.responseRecords()))
+	assertResponse(t, "Stryfnake._tuting._tcp.local.\t20\tCLASS32769\tSRV\t0 0 4711 myhost.local.", ds.ns.responseRecords())
+	assertResponse(t, "Stryfnake._tuting._tcp.local.\t3200\tCLASS32769\tTXT\t\"test=hej\" \"tjo=hopp\"", ds.ns.responseRecords())
+	assertResponse(t, "_tuting._tcp.local.\t3200\tIN\tPTR\tStryfnake._tuting._tcp.local.", ds.ns.responseRecords())
 
 }
 
+func TestRegisterUpdateTXT(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrecord := Register(ctx, 0, 3, "Stryfnake", "_tuting._tcp", "", "myhost", 4711, []string{"test=hej"}, func(flags int, serviceName, regType, domain string) {
+		rrc <- fmt.Sprint("Register: serviceName=", serviceName)
+	}, func(err error) {
+		rrc <- fmt.Sprint("TestRegisterUpdateTXT err=", err)
+	})
+	assertMessage(t, 2*time.Second, "Register: serviceName=Stryfnake", rrc)
+
+	txtRR := new(dns.TXT)
+	txtRR.Hdr = dns.RR_Header{Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 3200}
+	txtRR.Txt = []string{"test=hopp"}
+	addrecord(0, txtRR)
+
+	// The old TXT record is replaced and the new one announced.
+	txts := make(chan string, 1)
+	ds.cmdCh <- func() {
+		s := ""
+		for _, a := range ds.rrl.cache {
+			if txt, ok := a.rr.(*dns.TXT); ok {
+				s += fmt.Sprint(txt.Txt)
+			}
+		}
+		txts <- s
+	}
+	assert.Equal(t, "[test=hopp]", <-txts)
+	time.Sleep(1 * time.Millisecond)
+	assertResponse(t, "Stryfnake._tuting._tcp.local.\t3200\tCLASS32769\tTXT\t\"test=hopp\"", ds.ns.responseRecords())
+}
+
+func TestRegisterRename(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	txt := []string{"test=hej"}
+	Register(ctx, 0, 0, "Stryfnake", "_tuting._tcp", "", "myhost", 4711, txt, func(flags int, serviceName, regType, domain string) {
+		rrc <- fmt.Sprint("Register: serviceName=", serviceName, ", regType=", regType, ",domain=", domain)
+	}, func(err error) {
+		rrc <- fmt.Sprint("TestRegisterRename err=", err)
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("Stryfnake._tuting._tcp.local.", dns.TypeSRV, 0, 0, 80, "otherhost.local.")
+
+	assertMessage(t, 3*time.Second, "Register: serviceName=Stryfnake (2), regType=_tuting._tcp,domain=local", rrc)
+	time.Sleep(1 * time.Millisecond)
+	assertResponse(t, "_tuting._tcp.local.\t3200\tIN\tPTR\tStryfnake\\ \\(2\\)._tuting._tcp.local.", ds.ns.responseRecords())
+}
+
+func TestRegisterFollowHostRename(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+	setHostLabel("tuting")
+	defer setHostLabel("")
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Register(ctx, 0, 0, "Stryfnake", "_tuting._tcp", "", "", 4711, []string{"test=hej"}, func(flags int, serviceName, regType, domain string) {
+		rrc <- fmt.Sprint("Register: serviceName=", serviceName)
+	}, func(err error) {
+		rrc <- fmt.Sprint("TestRegisterFollowHostRename err=", err)
+	})
+	assertMessage(t, 2*time.Second, "Register: serviceName=Stryfnake", rrc)
+
+	setHostLabel("tuting-2")
+	notifyHostRename(HostName())
+
+	// The SRV record is probed and published again with the new target.
+	targets := make(chan string, 5)
+	last := ""
+	for ii := 0; ii < 200 && last != "tuting-2.local. "; ii++ {
+		ds.cmdCh <- func() {
+			s := ""
+			for _, a := range ds.rrl.cache {
+				if srv, ok := a.rr.(*dns.SRV); ok {
+					s += srv.Target + " "
+				}
+			}
+			targets <- s
+		}
+		last = <-targets
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "tuting-2.local. ", last)
+	ds.cmdCh <- func() {
+		targets <- fmt.Sprint(len(ds.rrl.cache))
+	}
+	assert.Equal(t, "3", <-targets)
+	assert.Equal(t, 0, len(rrc))
+}
+
+func TestRegisterNoAutoRename(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	errc := make(chan error, 5)
+	defer close(errc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	txt := []string{"test=hej"}
+	Register(ctx, NoAutoRename, 0, "Stryfnake", "_tuting._tcp", "", "myhost", 4711, txt, func(flags int, serviceName, regType, domain string) {
+		assert.Fail(t, fmt.Sprint("Conflicting service registered: ", serviceName))
+	}, func(err error) {
+		errc <- err
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("Stryfnake._tuting._tcp.local.", dns.TypeSRV, 0, 0, 80, "otherhost.local.")
+
+	err := <-errc
+	_, ok := err.(*ConflictError)
+	assert.True(t, ok)
+
+	// NoAutoRename needs an explicit name
+	Register(ctx, NoAutoRename, 0, "", "_tuting._tcp", "", "myhost", 4711, txt, nil, func(err error) {
+		errc <- err
+	})
+	assert.Equal(t, errBadFlags, <-errc)
+}
+
 func assertMessage(t *testing.T, timeout time.Duration, expected string, msgch <-chan string) {
 	tmr := time.NewTimer(timeout)
 	select {
@@ -7,6 +7,13 @@ import (
 	"github.com/miekg/dns"
 )
 
+// After this many conflicts wait before probing the next alternative name.
+const maxProbeConflicts = 15
+const probeConflictDelay = 5 * time.Second
+
+// Time to wait before probing again after losing a simultaneous probe tiebreak.
+const probeDeferDelay = time.Second
+
 /*
 Callback when a record has been registered.
 record is the newly registered record.
@@ -16,7 +23,10 @@ type RecordRegistered func(record dns.RR, flags int)
 
 /*
 Registrar function, will register dns.RR records
-flags may be dnssd.SHARED or dnssd.UNIQUE.
+flags may be dnssd.SHARED or dnssd.UNIQUE. Unique records are probed for before they are
+registered and renamed to "<name>-2", "<name>-3", ... on a conflict unless dnssd.NoAutoRename
+is set in which case a *ConflictError is reported to the error callback. The final record
+is passed to the RecordRegistered listener.
 ifIndex The index of interface to register the record to. If 0 it will be registered on all interfaces.
 record is the dns.RR record to register.
 */
@@ -25,7 +35,9 @@ type RegisterRecord func(ctx context.Context, flags Flags, ifIndex int, record d
 /*
 Create a DNSSDRecordRegistrar allowing efficient registration of multiple individual records.
 listener will be called when a record has been registered. errc will be called
-if there is an error with the registrar.
+if there is an error with the registrar. Unique records are watched for conflicts
+after they have been announced, a conflict causes the record to be probed again and
+the listener will be called with the renamed record or errc with a *ConflictError.
 The RegisterRecord closure returned is used to record new register entries.
 */
 func CreateRecordRegistrar(listener RecordRegistered, errc ErrCallback) RegisterRecord {
@@ -37,47 +49,179 @@ func CreateRecordRegistrar(listener RecordRegistered, errc ErrCallback) Register
 			return
 		}
 		go func() {
-			if flags&Unique != 0 {
-				// Only probe if the record is supposed to be unique
-				rrChan := make(chan dns.RR, 2)
-				question := questionFromRRHeader(record.Header())
-				response := func(flags Flags, ifIndex int, rr dns.RR) {
-					rrChan <- rr
-				}
-				for count := 3; count > 0; count-- {
-					ctxc, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
-					cb := makeCallback("probe", record, ctxc, ifIndex, response)
-					ds.cmdCh <- func() {
-						dnssdlog.Info.Println("DNSSD PROBE=", question)
-						ds.runProbe(ifIndex, question, cb)
-					}
-
-					select {
-					case <-ctxc.Done():
-						// Timeout of request
-						cancel() // Should already be cancelled actually, govet -1!
-					case rr := <-rrChan:
-						// We have received a response on the record we wish to publish.
-						cancel()
-						if rr.String() == record.String() {
-							// TODO: create a new name or report an error.
-						}
+			conflicts := make(chan dns.RR, 1)
+			var registered dns.RR
+			for {
+				if flags&Unique != 0 {
+					// Only probe if the record is supposed to be unique
+					record = probeAndRename(ctx, flags, ifIndex, record, errc)
+					if record == nil {
 						return
 					}
 				}
-			}
-
-			publishTime := 20
-			// Publish with exponential backoff: ", name, ": 0, 20, 40, 80, 160, 320, 640, 1280
-			dnssdlog.Info.Println("DNSSD PUBLISH=", record)
-			listener(record, 0)
-			for count := 8; count > 0; count-- {
+				announceRecord(ctx, flags, ifIndex, record, conflicts)
+				// The record is published before the listener is called so
+				// the listener may update it.
+				if registered == nil || !matchRRs(registered, record) {
+					dnssdlog.Info.Println("DNSSD PUBLISH=", record)
+					listener(record, 0)
+					registered = record
+				}
+				if flags&Unique == 0 {
+					return
+				}
+				conflict := waitForConflict(ctx, conflicts)
+				if conflict == nil {
+					return
+				}
+				// Someone else is claiming our unique record, stop answering
+				// for it and probe again, RFC6762 section 9.
+				dnssdlog.Info.Println("DNSSD CONFLICT AFTER ANNOUNCE=", record, ", with=", conflict)
 				ds.cmdCh <- func() {
-					ds.publish(ctx, flags, ifIndex, record)
+					ds.unpublish(ifIndex, record)
 				}
-				time.Sleep(time.Duration(publishTime) * time.Millisecond)
-				publishTime *= 2
 			}
 		}()
 	}
 }
+
+// Probe for a unique record, renaming it on conflicts unless NoAutoRename
+// is set in which case the conflict is reported to errc. Return the record
+// to announce or nil if no record should be announced.
+func probeAndRename(ctx context.Context, flags Flags, ifIndex int, record dns.RR, errc ErrCallback) dns.RR {
+	conflicts := 0
+	for {
+		conflict := probeRecord(ctx, ifIndex, record)
+		if contextIsClosed(ctx) {
+			return nil
+		}
+		if conflict == nil {
+			return record
+		}
+		dnssdlog.Info.Println("DNSSD CONFLICT=", record, ", with=", conflict)
+		if flags&NoAutoRename != 0 {
+			errc(&ConflictError{record, conflict})
+			return nil
+		}
+		conflicts++
+		if conflicts >= maxProbeConflicts {
+			// Someone is hogging the names, slow down.
+			time.Sleep(probeConflictDelay)
+		}
+		record = renameRecord(record)
+	}
+}
+
+// Publish a record, the processing loop announces it, see SetAnnouncements.
+// Conflicts with a unique record are sent to conflicts.
+func announceRecord(ctx context.Context, flags Flags, ifIndex int, record dns.RR, conflicts chan dns.RR) {
+	// Drop any stale conflict reported before we started over.
+	select {
+	case <-conflicts:
+	default:
+	}
+	conflict := func(rr dns.RR) {
+		select {
+		case conflicts <- rr:
+		default:
+		}
+	}
+
+	// The announcements are sent by the processing loop.
+	ds.cmdCh <- func() {
+		ds.publish(ctx, flags, ifIndex, record, conflict)
+	}
+}
+
+// Watch a published unique record for conflicts until the context is
+// closed. Return the conflicting record or nil if the context was closed.
+func waitForConflict(ctx context.Context, conflicts chan dns.RR) dns.RR {
+	select {
+	case rr := <-conflicts:
+		return rr
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// Probe the network for a unique record by sending three probes 250ms apart.
+// Return the first conflicting record received or nil if no other host
+// claims the name or the context was closed. If another host is probing
+// for the same name at the same time and wins the tiebreak we wait a
+// second and start probing again.
+func probeRecord(ctx context.Context, ifIndex int, record dns.RR) dns.RR {
+	rrChan := make(chan dns.RR, 2)
+	question := questionFromRRHeader(record.Header())
+	response := func(flags Flags, ifIndex int, rr dns.RR) {
+		select {
+		case rrChan <- rr:
+		default:
+		}
+	}
+	p := makeProbe(ifIndex, record)
+	ds.cmdCh <- func() {
+		ds.ps.add(p)
+	}
+	defer func() {
+		ds.cmdCh <- func() {
+			ds.ps.remove(p)
+		}
+	}()
+
+	// Start over from the first probe if we lose a tiebreak.
+	for {
+		conflict, lost := sendProbes(ctx, ifIndex, question, record, p, rrChan, response)
+		if !lost {
+			return conflict
+		}
+		dnssdlog.Info.Println("DNSSD PROBE DEFERRED=", question)
+		time.Sleep(probeDeferDelay)
+		if contextIsClosed(ctx) {
+			return nil
+		}
+	}
+}
+
+// Send three probes 250ms apart. Return the first conflicting record
+// received, or true if a simultaneous probe won the tiebreak.
+func sendProbes(ctx context.Context, ifIndex int, question *dns.Question, record dns.RR, p *probe, rrChan chan dns.RR, response QueryAnswered) (dns.RR, bool) {
+	for count := 0; count < 3; count++ {
+		ctxc, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
+		cb := makeCallback("probe", record, ctxc, ifIndex, response)
+		ds.cmdCh <- func() {
+			dnssdlog.Info.Println("DNSSD PROBE=", question)
+			ds.runProbe(ifIndex, question, record, cb)
+		}
+
+	waitForProbe:
+		for {
+			select {
+			case <-ctxc.Done():
+				// Timeout of request
+				break waitForProbe
+			case rr := <-rrChan:
+				// We have received a response on the record we wish to publish.
+				// Identical records are not in conflict.
+				if !matchRRData(rr, record) {
+					cancel()
+					return rr, false
+				}
+			case <-p.lost:
+				cancel()
+				return nil, true
+			}
+		}
+		cancel()
+		if contextIsClosed(ctx) {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Make a copy of the record with an alternative name to probe for.
+func renameRecord(record dns.RR) dns.RR {
+	rr := dns.Copy(record)
+	rr.Header().Name = alternativeName(rr.Header().Name)
+	return rr
+}
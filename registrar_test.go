@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -69,6 +70,102 @@ func TestRegistrarTwice(t *testing.T) {
 	assert.Equal(t, "Registrar:tuting.local.\t3600\tIN\tA\t10.20.30.40", <-rrc)
 }
 
+func TestRegistrarConflictRename(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	register := CreateRecordRegistrar(func(record dns.RR, flags int) {
+		rrc <- fmt.Sprint("Registrar:", record)
+	}, func(err error) {
+		rrc <- fmt.Sprint("TestRegistrarConflictRename err=", err)
+	})
+
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: "tuting.local.", Rrtype: dns.TypeA,
+		Class: dns.ClassINET, Ttl: 3600}
+	rr.A = net.IPv4(10, 20, 30, 40)
+	register(ctx, Unique, 0, rr)
+
+	time.Sleep(10 * time.Millisecond)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.20.30.41")
+
+	assertMessage(t, 2*time.Second, "Registrar:tuting-2.local.\t3600\tIN\tA\t10.20.30.40", rrc)
+}
+
+func TestRegistrarConflictNoAutoRename(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	errc := make(chan error, 5)
+	defer close(errc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	register := CreateRecordRegistrar(func(record dns.RR, flags int) {
+		assert.Fail(t, fmt.Sprint("Conflicting record registered: ", record))
+	}, func(err error) {
+		errc <- err
+	})
+
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: "tuting.local.", Rrtype: dns.TypeA,
+		Class: dns.ClassINET, Ttl: 3600}
+	rr.A = net.IPv4(10, 20, 30, 40)
+	register(ctx, Unique|NoAutoRename, 0, rr)
+
+	time.Sleep(10 * time.Millisecond)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.20.30.41")
+
+	err := <-errc
+	ce, ok := err.(*ConflictError)
+	assert.True(t, ok)
+	assert.Equal(t, "tuting.local.\t3600\tIN\tA\t10.20.30.40", ce.Record.String())
+	assert.Equal(t, "tuting.local.\t120\tIN\tA\t10.20.30.41", ce.Conflict.String())
+}
+
+func TestRegistrarConflictAfterAnnounce(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	defer close(rrc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	register := CreateRecordRegistrar(func(record dns.RR, flags int) {
+		rrc <- fmt.Sprint("Registrar:", record)
+	}, func(err error) {
+		rrc <- fmt.Sprint("TestRegistrarConflictAfterAnnounce err=", err)
+	})
+
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: "tuting.local.", Rrtype: dns.TypeA,
+		Class: dns.ClassINET, Ttl: 3600}
+	rr.A = net.IPv4(10, 20, 30, 40)
+	register(ctx, Unique, 0, rr)
+	assertMessage(t, 2*time.Second, "Registrar:tuting.local.\t3600\tIN\tA\t10.20.30.40", rrc)
+
+	// An identical record is not a conflict
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.20.30.40")
+	time.Sleep(10 * time.Millisecond)
+
+	// A conflicting record makes us probe again and the other host
+	// defends it so we rename.
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.20.30.41")
+	time.Sleep(50 * time.Millisecond)
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("tuting.local.", dns.TypeA, "10.20.30.41")
+
+	assertMessage(t, 2*time.Second, "Registrar:tuting-2.local.\t3600\tIN\tA\t10.20.30.40", rrc)
+}
+
 func NoTestRegistrarConflict(t *testing.T) {
 	d := make(chan bool)
 
@@ -32,7 +32,8 @@ regType is the registration type (for example _raop._tcp)
 domain is the domain of the service, normally the domain returned by Browse should be used.
 If domain is blank it will be replaced with the local domain
 response is a function that will be called when a service has been resolved. May be called
-several times. errc is an error callback.
+several times. errc is an error callback. errc is called with an error if the host tells
+that the service has no SRV record, a missing TXT record is resolved as an empty TXT record.
 */
 func Resolve(ctx context.Context, flags Flags, ifIndex int, serviceName, regType, domain string, response ServiceResolved, errc ErrCallback) {
 	if domain == "" {
@@ -48,6 +49,17 @@ func Resolve(ctx context.Context, flags Flags, ifIndex int, serviceName, regType
 			srv = rr
 		case *dns.TXT:
 			txt = rr
+		case *dns.NSEC:
+			if flags&NoSuchRecord == 0 || flags&RecordAdded == 0 {
+				return
+			}
+			if !isNegativeAnswer(&dns.Question{Name: qname, Qtype: dns.TypeSRV, Qclass: dns.ClassINET}, rr) {
+				// No TXT record, resolve with empty TXT data.
+				txt = &dns.TXT{Hdr: rr.Hdr}
+			} else if srv == nil {
+				errc(errNoSuchRecord)
+				return
+			}
 		}
 		if srv != nil && txt != nil {
 			dnssdlog.Debug.Println("TXT&SRV --> sending")
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/maghul/go.slf"
 	"github.com/miekg/dns"
@@ -34,3 +35,43 @@ func TestResolve1(t *testing.T) {
 
 	assert.Equal(t, "Resolved: name=rafael._airplay._tcp.local., host=www.facebook.it, port=4711, text=[hi=there]", <-rrc)
 }
+
+func TestResolveNoTXT(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Resolve(ctx, 0, 0, "rafael", "_airplay._tcp", "local",
+		func(flags Flags, ifIndex int, fullName, hostName string, port uint16, txt []string) {
+			rrc <- fmt.Sprint("Resolved: name=", fullName, ", host=", hostName, ", port=", port, ", text=", txt)
+		}, func(err error) {
+			rrc <- fmt.Sprint("TestResolveNoTXT err=", err)
+		})
+	ds.ns.msgCh <- fakeIncomingMsg(true).
+		addRR("rafael._airplay._tcp.local.", dns.TypeSRV, 0, 0, 4711, "www.facebook.it").
+		addRR("rafael._airplay._tcp.local.", dns.TypeNSEC, dns.TypeSRV)
+
+	assertMessage(t, time.Second, "Resolved: name=rafael._airplay._tcp.local., host=www.facebook.it, port=4711, text=[]", rrc)
+}
+
+func TestResolveNoSRV(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	rrc := make(chan string, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Resolve(ctx, 0, 0, "rafael", "_airplay._tcp", "local",
+		func(flags Flags, ifIndex int, fullName, hostName string, port uint16, txt []string) {
+			rrc <- "Resolved"
+		}, func(err error) {
+			rrc <- fmt.Sprint("TestResolveNoSRV err=", err)
+		})
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("rafael._airplay._tcp.local.", dns.TypeNSEC, dns.TypeTXT)
+
+	assertMessage(t, time.Second, "TestResolveNoSRV err=No such record", rrc)
+}
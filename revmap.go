@@ -19,3 +19,85 @@ package dnssd
       be sent to the mDNS IPv6 link-local multicast address FF02::FB or
       the mDNS IPv4 link-local multicast address 224.0.0.251.
 */
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var errNotLinkLocal error = errors.New("Not a link-local address")
+
+// The reverse mapping domains of link-local addresses.
+var linkLocalReverseDomains = []string{
+	"254.169.in-addr.arpa.",
+	"8.e.f.ip6.arpa.",
+	"9.e.f.ip6.arpa.",
+	"a.e.f.ip6.arpa.",
+	"b.e.f.ip6.arpa.",
+}
+
+/*
+This closure is called when an address has been mapped to a host name.
+flags may be dnssd.MoreComing and dnssd.RecordAdded, RecordAdded is not set if the
+mapping has been removed. ifIndex is the interface the mapping was found on.
+ip is the address looked up and hostName the name of the host using it.
+*/
+type AddrResolved func(flags Flags, ifIndex int, ip net.IP, hostName string)
+
+/*
+Look up the host name of a link-local address, 169.254/16 or fe80::/10, using the reverse
+mapping domains on the local link, RFC6762 section 4.
+ctx is the context used to cancel the lookup. flags are passed on to Query.
+ifIndex is the interface to look up the address on, 0 for all interfaces.
+response is called for each host name found. errc is called if the address is not
+link-local or if the lookup fails.
+*/
+func LookupAddr(ctx context.Context, flags Flags, ifIndex int, ip net.IP, response AddrResolved, errc ErrCallback) {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		errc(err)
+		return
+	}
+	if !isLinkLocalReverseName(name) {
+		errc(errNotLinkLocal)
+		return
+	}
+	question := &dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET}
+	query(ctx, flags, ifIndex, question, func(flags Flags, ifIndex int, rr dns.RR) {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			response(flags, ifIndex, ip, ptr.Ptr)
+		}
+	}, errc)
+}
+
+// Check if a name is in the reverse mapping domain of a link-local address
+// and must be queried with multicast.
+func isLinkLocalReverseName(name string) bool {
+	name = strings.ToLower(name)
+	for _, domain := range linkLocalReverseDomains {
+		if strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Make the reverse mapping PTR record for one of our link-local
+// addresses. Returns nil for other addresses.
+func makeReverseRecord(hostName string, ip net.IP) dns.RR {
+	if !ip.IsLinkLocalUnicast() {
+		return nil
+	}
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil
+	}
+	ptr := new(dns.PTR)
+	ptr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 3200}
+	ptr.Ptr = hostName
+	return ptr
+}
@@ -0,0 +1,52 @@
+package dnssd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLinkLocalReverseName(t *testing.T) {
+	assert.True(t, isLinkLocalReverseName("1.0.254.169.in-addr.arpa."))
+	assert.True(t, isLinkLocalReverseName("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.E.F.ip6.arpa."))
+	assert.True(t, isLinkLocalReverseName("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.e.f.ip6.arpa."))
+	assert.False(t, isLinkLocalReverseName("1.0.168.192.in-addr.arpa."))
+	assert.False(t, isLinkLocalReverseName("254.169.in-addr.arpa."))
+}
+
+func TestMakeReverseRecord(t *testing.T) {
+	rr := makeReverseRecord("tuting.local.", net.ParseIP("169.254.3.4"))
+	assert.Equal(t, "4.3.254.169.in-addr.arpa.\t3200\tIN\tPTR\ttuting.local.", rr.String())
+
+	rr = makeReverseRecord("tuting.local.", net.ParseIP("fe80::1"))
+	assert.Equal(t, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.", rr.Header().Name)
+
+	assert.Nil(t, makeReverseRecord("tuting.local.", net.ParseIP("192.168.1.1")))
+}
+
+func TestLookupAddr(t *testing.T) {
+	ds, _ = makeTestDnssd(t)
+	go ds.processing()
+
+	var err error
+	LookupAddr(context.Background(), None, 0, net.ParseIP("192.168.1.1"), nil, func(e error) {
+		err = e
+	})
+	assert.Equal(t, errNotLinkLocal, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	names := make(chan string, 5)
+	LookupAddr(ctx, None, 0, net.ParseIP("169.254.3.4"), func(flags Flags, ifIndex int, ip net.IP, hostName string) {
+		names <- fmt.Sprint(ip, "=", hostName)
+	}, nil)
+
+	ds.ns.msgCh <- fakeIncomingMsg(true).addRR("4.3.254.169.in-addr.arpa.", dns.TypePTR, "tuting.local.")
+	assertMessage(t, time.Second, "169.254.3.4=tuting.local.", names)
+	assert.Equal(t, ";4.3.254.169.in-addr.arpa.\tIN\t PTR", ds.ns.queryQuestions()[0].String())
+}
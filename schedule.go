@@ -0,0 +1,481 @@
+package dnssd
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A record scheduled to be sent in a response, as a known answer or
+// in the authority section of a probe. Each record has its own send
+// time so it can be suppressed or rescheduled individually. Responses
+// with a destination address are sent unicast, otherwise multicast.
+type pendingRecord struct {
+	ifIndex int
+	rr      dns.RR
+	to      net.Addr
+	at      time.Time
+}
+
+// A question scheduled to be sent. Probe questions are sent with the
+// records probed for in the authority section. Unicast questions are
+// sent with the QU bit set.
+type pendingQuestion struct {
+	ifIndex int
+	q       dns.Question
+	probe   bool
+	unicast bool
+	at      time.Time
+}
+
+// A message that is already built, such as a follow-up packet of known
+// answers, waiting to be sent.
+type pendingMessage struct {
+	ifIndex int
+	msg     *dns.Msg
+	at      time.Time
+}
+
+// Delay between the packets of a multi-packet known-answer list.
+const followUpDelay = 10 * time.Millisecond
+
+// Schedule a response record to be sent at the given time. Unique records
+// are sent with the cache-flush bit set, RFC6762 section 10.2.
+func (nss *netserver) sendResponseRecord(ifIndex int, rr dns.RR, flags Flags, at time.Time) {
+	if flags&Unique != 0 {
+		rr = dns.Copy(rr)
+		rr.Header().Class |= cacheFlushBit
+	}
+	nss.responses = appendRecord(nss.responses, ifIndex, rr, nil, at, "Response Record=")
+}
+
+// Schedule a response record to be sent directly to a querier that asked
+// for a unicast response.
+func (nss *netserver) sendUnicastResponseRecord(ifIndex int, rr dns.RR, flags Flags, to net.Addr, at time.Time) {
+	if flags&Unique != 0 {
+		rr = dns.Copy(rr)
+		rr.Header().Class |= cacheFlushBit
+	}
+	nss.responses = appendRecord(nss.responses, ifIndex, rr, to, at, "Unicast Response Record=")
+}
+
+// The time a multicast response record is scheduled to be sent, or at
+// if it isn't pending.
+func (nss *netserver) responseTime(ifIndex int, rr dns.RR, at time.Time) time.Time {
+	for _, pr := range nss.responses {
+		if pr.ifIndex == ifIndex && pr.to == nil && matchRRDataIgnoreFlush(pr.rr, rr) {
+			return pr.at
+		}
+	}
+	return at
+}
+
+// Schedule a record for the additional section of the responses sent at
+// the given time. Additional records are sent unicast if to is set and
+// never sent without answers.
+func (nss *netserver) sendAdditionalRecord(ifIndex int, rr dns.RR, flags Flags, to net.Addr, at time.Time) {
+	if flags&Unique != 0 {
+		rr = dns.Copy(rr)
+		rr.Header().Class |= cacheFlushBit
+	}
+	nss.additionals = appendRecord(nss.additionals, ifIndex, rr, to, at, "Additional Record=")
+}
+
+// Add a known answer to be sent with the questions it answers.
+func (nss *netserver) sendKnownAnswer(ifIndex int, rr dns.RR) {
+	nss.knownAnswers = appendRecord(nss.knownAnswers, ifIndex, rr, nil, time.Time{}, "Known Answer=")
+}
+
+// Schedule a probe for a record to be sent at the given time.
+func (nss *netserver) sendProbe(ifIndex int, q *dns.Question, rr dns.RR, at time.Time) {
+	nss.questions = appendQuestion(nss.questions, ifIndex, q, true, false, at, "Probe=")
+	nss.probeRecords = appendRecord(nss.probeRecords, ifIndex, rr, nil, time.Time{}, "Probe Record=")
+}
+
+// Schedule a question to be sent at the given time.
+func (nss *netserver) sendQuestion(ifIndex int, q *dns.Question, at time.Time) {
+	nss.questions = appendQuestion(nss.questions, ifIndex, q, false, false, at, "Question=")
+}
+
+// Schedule a question asking for unicast responses to be sent at the given time.
+func (nss *netserver) sendUnicastQuestion(ifIndex int, q *dns.Question, at time.Time) {
+	nss.questions = appendQuestion(nss.questions, ifIndex, q, false, true, at, "Unicast Question=")
+}
+
+// Append a question unless it is already pending, a pending question
+// is sent at the earliest of the scheduled times.
+func appendQuestion(pqs []*pendingQuestion, ifIndex int, q *dns.Question, probe, unicast bool, at time.Time, ref string) []*pendingQuestion {
+	for _, pq := range pqs {
+		if pq.ifIndex == ifIndex && matchQuestions(&pq.q, q) {
+			pq.at = getNextTime(pq.at, at)
+			pq.probe = pq.probe || probe
+			pq.unicast = pq.unicast || unicast
+			return pqs
+		}
+	}
+	qlog.Debug.Println(ref, q.String())
+	return append(pqs, &pendingQuestion{ifIndex, *q, probe, unicast, at})
+}
+
+// Append a record unless it is already pending, a pending record with the
+// same data is replaced so the latest TTL is sent at the earliest of the
+// scheduled times.
+func appendRecord(prs []*pendingRecord, ifIndex int, rr dns.RR, to net.Addr, at time.Time, ref string) []*pendingRecord {
+	for _, pr := range prs {
+		if pr.ifIndex == ifIndex && matchAddr(pr.to, to) && matchRRData(pr.rr, rr) {
+			pr.rr = rr
+			pr.at = getNextTime(pr.at, at)
+			return prs
+		}
+	}
+	qlog.Debug.Println(ref, rr)
+	return append(prs, &pendingRecord{ifIndex, rr, to, at})
+}
+
+func matchAddr(a1, a2 net.Addr) bool {
+	if a1 == nil || a2 == nil {
+		return a1 == a2
+	}
+	return a1.String() == a2.String()
+}
+
+/*
+Drop our scheduled questions which another host has just asked unless
+the other host included known answers that we don't have. Responders
+would then hold back records we have not seen, RFC6762 section 7.3.
+*/
+func (nss *netserver) suppressDuplicateQuestions(ifIndex int, msg *dns.Msg) {
+	jj := 0
+	for _, pq := range nss.questions {
+		if pq.probe || !isDuplicateQuestion(pq, ifIndex, msg, nss.knownAnswers) {
+			nss.questions[jj] = pq
+			jj++
+		} else {
+			qlog.Debug.Println("Duplicate question suppressed:", pq.q.String())
+		}
+	}
+	nss.questions = nss.questions[0:jj]
+}
+
+func isDuplicateQuestion(pq *pendingQuestion, ifIndex int, msg *dns.Msg, knownAnswers []*pendingRecord) bool {
+	if pq.ifIndex != 0 && pq.ifIndex != ifIndex {
+		return false
+	}
+	asked := false
+	for _, q := range msg.Question {
+		if matchQuestions(&pq.q, &q) {
+			asked = true
+		}
+	}
+	if !asked {
+		return false
+	}
+	var ours []dns.RR
+	for _, ka := range knownAnswers {
+		if ka.ifIndex == pq.ifIndex {
+			ours = append(ours, ka.rr)
+		}
+	}
+	for _, rr := range msg.Answer {
+		if matchQuestionAndRR(&pq.q, rr) && !containsRecord(ours, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Drop our scheduled responses which another responder has just multicast
+with at least half of our TTL, RFC6762 section 7.4.
+*/
+func (nss *netserver) suppressDuplicateAnswers(ifIndex int, rrs []dns.RR) {
+	jj := 0
+	for _, pr := range nss.responses {
+		if !isDuplicateAnswer(pr, ifIndex, rrs) {
+			nss.responses[jj] = pr
+			jj++
+		} else {
+			qlog.Debug.Println("Duplicate answer suppressed:", pr.rr)
+		}
+	}
+	nss.responses = nss.responses[0:jj]
+}
+
+func isDuplicateAnswer(pr *pendingRecord, ifIndex int, rrs []dns.RR) bool {
+	if pr.to != nil || (pr.ifIndex != 0 && pr.ifIndex != ifIndex) {
+		return false
+	}
+	// Never suppress goodbyes.
+	ttl := pr.rr.Header().Ttl
+	if ttl == 0 {
+		return false
+	}
+	for _, rr := range rrs {
+		if matchRRDataIgnoreFlush(pr.rr, rr) && rr.Header().Ttl*2 >= ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a list of records contains a record with the same data.
+func containsRecord(rrs []dns.RR, rr dns.RR) bool {
+	for _, trr := range rrs {
+		if matchRRDataIgnoreFlush(trr, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The records not in remove.
+func withoutRecords(rrs, remove []dns.RR) []dns.RR {
+	var kept []dns.RR
+	for _, rr := range rrs {
+		if !containsRecord(remove, rr) {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+// Send all records and questions which are due. Known answers are sent
+// with the questions they answer and probe records with their probes.
+// Return the time the next pending record or question is due.
+func (nss *netserver) sendPending() time.Time {
+	now := time.Now()
+	var next time.Time
+
+	var responses, additionals []*pendingRecord
+	nss.responses, responses = takeDueRecords(nss.responses, now, &next)
+	nss.additionals, additionals = takeDueRecords(nss.additionals, now, &next)
+
+	var questions []*pendingQuestion
+	jj := 0
+	for _, pq := range nss.questions {
+		if pq.at.After(now) {
+			next = getNextTime(next, pq.at)
+			nss.questions[jj] = pq
+			jj++
+		} else {
+			questions = append(questions, pq)
+		}
+	}
+	nss.questions = nss.questions[0:jj]
+
+	// Records and questions for interface 0 are sent on all interfaces.
+	var ifIndexes []int
+	for _, pr := range responses {
+		ifIndexes = appendIfIndex(ifIndexes, pr.ifIndex)
+	}
+	for _, pq := range questions {
+		ifIndexes = appendIfIndex(ifIndexes, pq.ifIndex)
+	}
+	for _, ifIndex := range ifIndexes {
+		nss.sendPendingOnInterface(ifIndex, responses, additionals, questions, now, &next)
+	}
+
+	// Known answers and probe records are only kept for questions still pending.
+	nss.knownAnswers = nss.keepForQuestions(nss.knownAnswers, false)
+	nss.probeRecords = nss.keepForQuestions(nss.probeRecords, true)
+	nss.followUps = nss.sendDueFollowUps(now, &next)
+	return next
+}
+
+// Send the responses and questions due for an interface.
+func (nss *netserver) sendPendingOnInterface(ifIndex int, responses, additionals []*pendingRecord, questions []*pendingQuestion, now time.Time, next *time.Time) {
+	size := packetSize(ifIndex)
+
+	response := &dns.Msg{}
+	response.Response = true
+	var unicast []*pendingRecord
+	for _, pr := range responses {
+		if pr.ifIndex != 0 && pr.ifIndex != ifIndex {
+			continue
+		}
+		if pr.to != nil {
+			unicast = append(unicast, pr)
+		} else {
+			response.Answer = append(response.Answer, pr.rr)
+		}
+	}
+	if len(response.Answer) > 0 {
+		response.Extra = withoutRecords(additionalRecords(additionals, ifIndex, nil), response.Answer)
+		for _, msg := range splitResponse(response, size) {
+			nss.sendMessage(ifIndex, msg)
+		}
+	}
+	// One unicast response for each querier.
+	for len(unicast) > 0 {
+		to := unicast[0].to
+		msg := &dns.Msg{}
+		msg.Response = true
+		jj := 0
+		for _, pr := range unicast {
+			if matchAddr(pr.to, to) {
+				msg.Answer = append(msg.Answer, pr.rr)
+			} else {
+				unicast[jj] = pr
+				jj++
+			}
+		}
+		unicast = unicast[0:jj]
+		msg.Extra = withoutRecords(additionalRecords(additionals, ifIndex, to), msg.Answer)
+		for _, msg := range splitResponse(msg, size) {
+			nss.sendUnicastMessage(ifIndex, msg, to)
+		}
+	}
+
+	var pqs []*pendingQuestion
+	for _, pq := range questions {
+		if pq.ifIndex == 0 || pq.ifIndex == ifIndex {
+			pqs = append(pqs, pq)
+		}
+	}
+	for _, query := range nss.makeQueries(pqs, size) {
+		query, followUps := splitKnownAnswers(query, size)
+		for ii, msg := range followUps {
+			nss.followUps = append(nss.followUps, &pendingMessage{ifIndex, msg, now.Add(time.Duration(ii+1) * followUpDelay)})
+			*next = getNextTime(*next, now.Add(followUpDelay))
+		}
+		nss.sendMessage(ifIndex, query)
+	}
+}
+
+// The additional records for responses on an interface to a destination.
+func additionalRecords(additionals []*pendingRecord, ifIndex int, to net.Addr) []dns.RR {
+	var rrs []dns.RR
+	for _, pr := range additionals {
+		if (pr.ifIndex == 0 || pr.ifIndex == ifIndex) && matchAddr(pr.to, to) {
+			rrs = append(rrs, pr.rr)
+		}
+	}
+	return rrs
+}
+
+// Add the interfaces to send on for an ifIndex. Zero means all
+// multicast interfaces and negative means local only.
+func appendIfIndex(ifIndexes []int, ifIndex int) []int {
+	if ifIndex < 0 {
+		return ifIndexes
+	}
+	if ifIndex == 0 {
+		for _, iface := range multicastInterfaces() {
+			ifIndexes = appendIfIndex(ifIndexes, iface.Index)
+		}
+		return ifIndexes
+	}
+	for _, ii := range ifIndexes {
+		if ii == ifIndex {
+			return ifIndexes
+		}
+	}
+	return append(ifIndexes, ifIndex)
+}
+
+// Split pending records into those to keep and those due now. The
+// time of the next record to send is merged into next.
+func takeDueRecords(prs []*pendingRecord, now time.Time, next *time.Time) ([]*pendingRecord, []*pendingRecord) {
+	var due []*pendingRecord
+	jj := 0
+	for _, pr := range prs {
+		if pr.at.After(now) {
+			*next = getNextTime(*next, pr.at)
+			prs[jj] = pr
+			jj++
+		} else {
+			due = append(due, pr)
+		}
+	}
+	return prs[0:jj], due
+}
+
+// Send follow-up packets that are due and return those left.
+func (nss *netserver) sendDueFollowUps(now time.Time, next *time.Time) []*pendingMessage {
+	jj := 0
+	for _, pm := range nss.followUps {
+		if pm.at.After(now) {
+			*next = getNextTime(*next, pm.at)
+			nss.followUps[jj] = pm
+			jj++
+		} else {
+			nss.sendMessage(pm.ifIndex, pm.msg)
+		}
+	}
+	return nss.followUps[0:jj]
+}
+
+// Build queries for the questions to send. Questions, with their probe
+// records, are added to a query until it is full and the known answers
+// for each query are then added.
+func (nss *netserver) makeQueries(pqs []*pendingQuestion, size int) []*dns.Msg {
+	var queries []*dns.Msg
+	var asked [][]*pendingQuestion
+	query := &dns.Msg{}
+	var qpqs []*pendingQuestion
+	for _, pq := range pqs {
+		q := pq.q
+		if pq.unicast {
+			q.Qclass |= unicastResponseBit
+		}
+		ns := len(query.Ns)
+		query.Question = append(query.Question, q)
+		if pq.probe {
+			for _, pr := range nss.probeRecords {
+				if pr.ifIndex == pq.ifIndex && pr.rr.Header().Name == pq.q.Name {
+					query.Ns = appendIfMissing(query.Ns, pr.rr)
+				}
+			}
+		}
+		if len(qpqs) > 0 && query.Len() > size {
+			query.Question = query.Question[0 : len(query.Question)-1]
+			added := query.Ns[ns:]
+			query.Ns = query.Ns[0:ns]
+			queries = append(queries, query)
+			asked = append(asked, qpqs)
+			query = &dns.Msg{}
+			query.Question = []dns.Question{q}
+			query.Ns = added
+			qpqs = nil
+		}
+		qpqs = append(qpqs, pq)
+	}
+	if len(qpqs) > 0 {
+		queries = append(queries, query)
+		asked = append(asked, qpqs)
+	}
+	for ii, query := range queries {
+		for _, pq := range asked[ii] {
+			for _, ka := range nss.knownAnswers {
+				if ka.ifIndex == pq.ifIndex && matchQuestionAndRR(&pq.q, ka.rr) {
+					query.Answer = appendIfMissing(query.Answer, ka.rr)
+				}
+			}
+		}
+	}
+	return queries
+}
+
+func (nss *netserver) keepForQuestions(prs []*pendingRecord, probe bool) []*pendingRecord {
+	jj := 0
+	for _, pr := range prs {
+		for _, pq := range nss.questions {
+			if pq.ifIndex == pr.ifIndex && (pq.probe || !probe) && matchQuestionAndRR(&pq.q, pr.rr) {
+				prs[jj] = pr
+				jj++
+				break
+			}
+		}
+	}
+	return prs[0:jj]
+}
+
+func appendIfMissing(rrs []dns.RR, rr dns.RR) []dns.RR {
+	for _, trr := range rrs {
+		if trr == rr {
+			return rrs
+		}
+	}
+	return append(rrs, rr)
+}
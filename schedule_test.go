@@ -0,0 +1,128 @@
+package dnssd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppressDuplicateQuestions(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	later := time.Now().Add(time.Second)
+	q := makeTestPtrQuestion("_tuting._tcp.local.").q
+
+	ns.sendQuestion(2, q, later)
+	ns.sendKnownAnswer(2, makeTestPtrAnswer(2, q.Name, "hoppla", 120).rr)
+
+	// Another host asks with a known answer we don't have, we must
+	// still ask or the record is held back from us.
+	im := fakeIncomingMsg(false).
+		addRR(q.Name, dns.TypePTR, "hoppla").
+		addRR(q.Name, dns.TypePTR, "tjosan")
+	im.msg.Question = []dns.Question{*q}
+	ns.suppressDuplicateQuestions(im.ifIndex, im.msg)
+	assert.Equal(t, 1, len(ns.queryQuestions()))
+
+	// Another host asks with our known answer, no need to ask again.
+	im = fakeIncomingMsg(false).addRR(q.Name, dns.TypePTR, "hoppla")
+	im.msg.Question = []dns.Question{*q}
+	ns.suppressDuplicateQuestions(im.ifIndex, im.msg)
+	assert.Equal(t, 0, len(ns.queryQuestions()))
+
+	// Another host asks without known answers, all answers will be sent.
+	ns.sendQuestion(2, q, later)
+	im = fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{*q}
+	ns.suppressDuplicateQuestions(im.ifIndex, im.msg)
+	assert.Equal(t, 0, len(ns.queryQuestions()))
+}
+
+func TestSuppressDuplicateAnswers(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	later := time.Now().Add(time.Second)
+	a := makeTestPtrAnswer(2, "_tuting._tcp.local.", "hoppla", 120)
+	ns.sendResponseRecord(2, a.rr, Shared, later)
+
+	// Another responder sends it with less than half our TTL
+	ns.suppressDuplicateAnswers(2, fakeIncomingMsg(true).addRR(a.rr.Header().Name, dns.TypePTR, "hoppla").ttl(59).msg.Answer)
+	assert.Equal(t, 1, len(ns.responseRecords()))
+
+	// Another responder sends something else
+	ns.suppressDuplicateAnswers(2, fakeIncomingMsg(true).addRR(a.rr.Header().Name, dns.TypePTR, "yowza").msg.Answer)
+	assert.Equal(t, 1, len(ns.responseRecords()))
+
+	ns.suppressDuplicateAnswers(2, fakeIncomingMsg(true).addRR(a.rr.Header().Name, dns.TypePTR, "hoppla").ttl(60).msg.Answer)
+	assert.Equal(t, 0, len(ns.responseRecords()))
+}
+
+func TestSendPending(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	now := time.Now()
+	later := now.Add(time.Second)
+	q1 := makeTestPtrQuestion("_tuting._tcp.local.").q
+	q2 := makeTestPtrQuestion("_tjohej._tcp.local.").q
+
+	ns.sendQuestion(2, q1, now)
+	ns.sendKnownAnswer(2, makeTestPtrAnswer(2, q1.Name, "hoppla", 120).rr)
+	ns.sendQuestion(2, q2, later)
+	ns.sendKnownAnswer(2, makeTestPtrAnswer(2, q2.Name, "hoppla", 120).rr)
+	ns.sendResponseRecord(2, makeTestPtrAnswer(2, "a", "b", 120).rr, Shared, later)
+
+	assert.Equal(t, later, ns.sendPending())
+	assert.Equal(t, []dns.Question{*q2}, ns.queryQuestions())
+	assert.Equal(t, 1, len(ns.knownAnswerRecords()))
+	assert.Equal(t, q2.Name, ns.knownAnswerRecords()[0].Header().Name)
+	assert.Equal(t, 1, len(ns.responseRecords()))
+}
+
+func TestSendPendingUnicast(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	now := time.Now()
+	to := fakeIncomingMsg(false).from
+	rr := makeTestPtrAnswer(2, "a", "b", 120).rr
+
+	ns.sendUnicastQuestion(2, makeTestPtrQuestion("_tuting._tcp.local.").q, now)
+	ns.sendResponseRecord(2, rr, Shared, now)
+	ns.sendUnicastResponseRecord(2, rr, Shared, to, now)
+	assert.Equal(t, 2, len(ns.responses))
+
+	// Unicast responses are never suppressed by multicast answers.
+	ns.suppressDuplicateAnswers(2, []dns.RR{rr})
+	assert.Equal(t, 1, len(ns.responses))
+	assert.Equal(t, to, ns.responses[0].to)
+	assert.True(t, ns.questions[0].unicast)
+}
+
+func TestMakeQueries(t *testing.T) {
+	ns, _ := makeTestNetserver()
+	var pqs []*pendingQuestion
+	for _, name := range []string{"_tuting._tcp.local.", "_tjohej._tcp.local.", "_hoppla._tcp.local."} {
+		pqs = append(pqs, &pendingQuestion{ifIndex: 2, q: *makeTestPtrQuestion(name).q})
+		ns.sendKnownAnswer(2, makeTestPtrAnswer(2, name, "hoppla", 120).rr)
+	}
+
+	queries := ns.makeQueries(pqs, 9000)
+	assert.Equal(t, 1, len(queries))
+	assert.Equal(t, 3, len(queries[0].Question))
+	assert.Equal(t, 3, len(queries[0].Answer))
+
+	queries = ns.makeQueries(pqs, 40)
+	assert.Equal(t, 3, len(queries))
+	for ii, query := range queries {
+		assert.Equal(t, []dns.Question{pqs[ii].q}, query.Question)
+		assert.Equal(t, 1, len(query.Answer))
+	}
+}
+
+func TestAppendIfIndex(t *testing.T) {
+	assert.Equal(t, []int{2}, appendIfIndex(nil, 2))
+	assert.Equal(t, []int{2}, appendIfIndex([]int{2}, 2))
+	assert.Equal(t, []int{2, 3}, appendIfIndex([]int{2}, 3))
+	assert.Nil(t, appendIfIndex(nil, -1))
+
+	all := appendIfIndex(nil, 0)
+	assert.Equal(t, len(multicastInterfaces()), len(all))
+	assert.Equal(t, all, appendIfIndex(all, 0))
+}
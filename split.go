@@ -0,0 +1,118 @@
+package dnssd
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Split a query with too many known answers to fit in a packet. The
+// first packet has the questions and each following packet only known
+// answers. TC is set on all but the last packet, RFC6762 section 7.2.
+func splitKnownAnswers(query *dns.Msg, size int) (*dns.Msg, []*dns.Msg) {
+	if query.Len() <= size {
+		return query, nil
+	}
+	kas := query.Answer
+	query.Answer = nil
+	var followUps []*dns.Msg
+	msg := query
+	for _, ka := range kas {
+		msg.Answer = append(msg.Answer, ka)
+		if msg.Len() > size && len(msg.Answer) > 1 {
+			msg.Answer = msg.Answer[0 : len(msg.Answer)-1]
+			msg.Truncated = true
+			msg = &dns.Msg{}
+			msg.Answer = []dns.RR{ka}
+			followUps = append(followUps, msg)
+		}
+	}
+	return query, followUps
+}
+
+// Split a response that doesn't fit in a packet into several responses.
+// The records of an rrset are kept in the same response unless the rrset
+// alone is too large. Additional records are only added where they fit.
+func splitResponse(response *dns.Msg, size int) []*dns.Msg {
+	if response.Len() <= size {
+		return []*dns.Msg{response}
+	}
+	extras := response.Extra
+	msg := response.Copy()
+	msg.Answer = nil
+	msg.Extra = nil
+	msgs := []*dns.Msg{msg}
+	for _, rrset := range groupRRSets(response.Answer) {
+		n := len(msg.Answer)
+		msg.Answer = append(msg.Answer, rrset...)
+		if msg.Len() <= size {
+			continue
+		}
+		if n > 0 {
+			// Start over with the rrset in a new response.
+			msg.Answer = msg.Answer[0:n]
+			msg = makeSplitResponse(response)
+			msgs = append(msgs, msg)
+			msg.Answer = rrset
+			if msg.Len() <= size {
+				continue
+			}
+		}
+		// The rrset doesn't fit in a single response.
+		msg.Answer = nil
+		for _, rr := range rrset {
+			msg.Answer = append(msg.Answer, rr)
+			if msg.Len() > size && len(msg.Answer) > 1 {
+				msg.Answer = msg.Answer[0 : len(msg.Answer)-1]
+				msg = makeSplitResponse(response)
+				msgs = append(msgs, msg)
+				msg.Answer = []dns.RR{rr}
+			}
+		}
+	}
+	for _, extra := range extras {
+		for _, msg := range msgs {
+			msg.Extra = append(msg.Extra, extra)
+			if msg.Len() <= size {
+				break
+			}
+			msg.Extra = msg.Extra[0 : len(msg.Extra)-1]
+		}
+	}
+	return msgs
+}
+
+// Make an empty response with the header of the response being split.
+func makeSplitResponse(response *dns.Msg) *dns.Msg {
+	msg := &dns.Msg{}
+	msg.MsgHdr = response.MsgHdr
+	return msg
+}
+
+// Group records into rrsets, records with the same name, type and class,
+// keeping the order of the first record of each rrset.
+func groupRRSets(rrs []dns.RR) [][]dns.RR {
+	var rrsets [][]dns.RR
+	for _, rr := range rrs {
+		found := false
+		for ii, rrset := range rrsets {
+			if sameRRSet(rrset[0], rr) {
+				rrsets[ii] = append(rrset, rr)
+				found = true
+				break
+			}
+		}
+		if !found {
+			rrsets = append(rrsets, []dns.RR{rr})
+		}
+	}
+	return rrsets
+}
+
+func sameRRSet(rr1, rr2 dns.RR) bool {
+	h1 := rr1.Header()
+	h2 := rr2.Header()
+	return h1.Rrtype == h2.Rrtype &&
+		h1.Class&^cacheFlushBit == h2.Class&^cacheFlushBit &&
+		strings.EqualFold(h1.Name, h2.Name)
+}
@@ -0,0 +1,81 @@
+package dnssd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitKnownAnswers(t *testing.T) {
+	query := &dns.Msg{}
+	query.Question = []dns.Question{*makeTestPtrQuestion("_tuting._tcp.local.").q}
+	for ii := 0; ii < 200; ii++ {
+		ptr := fmt.Sprintf("Service number %d._tuting._tcp.local.", ii)
+		query.Answer = append(query.Answer, makeTestPtrAnswer(2, "_tuting._tcp.local.", ptr, 120).rr)
+	}
+
+	first, followUps := splitKnownAnswers(query, packetSize(0))
+	assert.True(t, len(followUps) > 0)
+	msgs := append([]*dns.Msg{first}, followUps...)
+	count := 0
+	for ii, msg := range msgs {
+		assert.True(t, msg.Len() <= packetSize(0))
+		assert.Equal(t, ii < len(msgs)-1, msg.Truncated)
+		count += len(msg.Answer)
+	}
+	assert.Equal(t, 200, count)
+	assert.Equal(t, 1, len(first.Question))
+	assert.Equal(t, 0, len(followUps[0].Question))
+
+	small := &dns.Msg{}
+	small.Answer = query.Answer[0:2]
+	first, followUps = splitKnownAnswers(small, packetSize(0))
+	assert.Equal(t, small, first)
+	assert.Nil(t, followUps)
+}
+
+func TestSplitResponse(t *testing.T) {
+	response := &dns.Msg{}
+	response.Response = true
+	for ii := 0; ii < 100; ii++ {
+		name := fmt.Sprintf("_service%d._tcp.local.", ii%10)
+		ptr := fmt.Sprintf("Service number %d._tuting._tcp.local.", ii)
+		response.Answer = append(response.Answer, makeTestPtrAnswer(2, name, ptr, 120).rr)
+	}
+	response.Extra = []dns.RR{makeTestARecord("tuting.local.", "10.0.0.1")}
+
+	msgs := splitResponse(response, 1000)
+	assert.True(t, len(msgs) > 1)
+	count := 0
+	extras := 0
+	for _, msg := range msgs {
+		assert.True(t, msg.Response)
+		assert.True(t, msg.Len() <= 1000)
+		count += len(msg.Answer)
+		extras += len(msg.Extra)
+		// Each rrset of ten records fits and is kept together.
+		for _, rrset := range groupRRSets(msg.Answer) {
+			assert.Equal(t, 10, len(rrset))
+		}
+	}
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 1, extras)
+
+	// An rrset too large for one response is split.
+	msgs = splitResponse(response, 300)
+	count = 0
+	for _, msg := range msgs {
+		assert.True(t, msg.Len() <= 300)
+		count += len(msg.Answer)
+	}
+	assert.Equal(t, 100, count)
+
+	assert.Equal(t, []*dns.Msg{response}, splitResponse(response, 9000))
+}
+
+func TestPacketSize(t *testing.T) {
+	assert.Equal(t, 1452, packetSize(0))
+	assert.True(t, packetSize(1) <= maxMessageSize-ipUDPHeaderSize)
+}
@@ -7,35 +7,81 @@ import (
 	"github.com/miekg/dns"
 )
 
-func publishIpAddr(rgr RegisterRecord, name string, ip net.IP) {
+// Make an A record for an IPv4 address or an AAAA record for an IPv6 address.
+func makeAddressRecord(name string, ip net.IP) dns.RR {
 	hdr := dns.RR_Header{}
 	hdr.Name = name
 	hdr.Class = dns.ClassINET
 	hdr.Ttl = 3200
 
-	ctx := context.Background()
 	ip4 := ip.To4()
 	if ip4 != nil {
 		hdr.Rrtype = dns.TypeA
-		rgr(ctx, Shared, 0, &dns.A{Hdr: hdr, A: ip4})
+		return &dns.A{Hdr: hdr, A: ip4}
 	}
-	ip6 := ip.To16()
-	if ip6 != nil {
-		hdr.Rrtype = dns.TypeAAAA
-		rgr(ctx, Shared, 0, &dns.AAAA{Hdr: hdr, AAAA: ip6})
-	}
-
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: ip.To16()}
 }
 
-func startup() {
+// The addresses of an interface that can be published.
+func interfaceAddresses(iface *net.Interface) []net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		dnssdlog.Info.Println("Could not get addresses for interface", iface.Name, ":", err)
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip != nil && !ip.IsLoopback() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
 
-	rgr := CreateRecordRegistrar(func(record dns.RR, flags int) {
+// The address of an A or AAAA record, nil for other records.
+func recordAddress(rr dns.RR) net.IP {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A
+	case *dns.AAAA:
+		return v.AAAA
+	}
+	return nil
+}
 
+// Publish the addresses of each interface only on that interface so
+// a peer gets the addresses it can reach, RFC6762 section 14. errc is
+// called with address conflicts. The reverse mapping of a link-local
+// address is published as a unique record once the address record has
+// been probed. A conflicting reverse mapping is only logged.
+func publishInterfaceAddrs(ctx context.Context, flags Flags, name string, errc ErrCallback) {
+	rev := CreateRecordRegistrar(func(record dns.RR, flags int) {
+		dnssdlog.Info.Println("DNSSD REVERSE ADDRESS=", record)
 	}, func(err error) {
-
+		dnssdlog.Info.Println("DNSSD REVERSE ERROR=", err)
 	})
+	for _, iface := range multicastInterfaces() {
+		ifIndex := iface.Index
+		rgr := CreateRecordRegistrar(func(record dns.RR, flags int) {
+			dnssdlog.Info.Println("DNSSD HOST ADDRESS=", record)
+			if ptr := makeReverseRecord(name, recordAddress(record)); ptr != nil {
+				rev(ctx, Unique|NoAutoRename, ifIndex, ptr)
+			}
+		}, errc)
+		for _, ip := range interfaceAddresses(&iface) {
+			rgr(ctx, flags, ifIndex, makeAddressRecord(name, ip))
+		}
+	}
+}
 
-	//	publishIpAddr(rgr, "durer.local.", net.ParseIP("10.223.10.110"))
-	publishIpAddr(rgr, "flurer.local.", net.ParseIP("10.123.10.110"))
-
+func startup() {
+	publishHost(context.Background(), getHostLabel())
 }
@@ -0,0 +1,34 @@
+package dnssd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeAddressRecord(t *testing.T) {
+	rr := makeAddressRecord("tuting.local.", net.ParseIP("10.0.0.1"))
+	assert.Equal(t, "tuting.local.\t3200\tIN\tA\t10.0.0.1", rr.String())
+
+	rr = makeAddressRecord("tuting.local.", net.ParseIP("fe80::1"))
+	assert.Equal(t, "tuting.local.\t3200\tIN\tAAAA\tfe80::1", rr.String())
+}
+
+func TestInterfaceAddresses(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	assert.NoError(t, err)
+	for _, iface := range ifaces {
+		for _, ip := range interfaceAddresses(&iface) {
+			assert.False(t, ip.IsLoopback())
+		}
+	}
+}
+
+func TestRecordAddress(t *testing.T) {
+	ip := net.ParseIP("fe80::1")
+	assert.True(t, ip.Equal(recordAddress(makeAddressRecord("tuting.local.", ip))))
+	ip = net.ParseIP("10.0.0.1")
+	assert.True(t, ip.Equal(recordAddress(makeAddressRecord("tuting.local.", ip))))
+	assert.Nil(t, recordAddress(makeReverseRecord("tuting.local.", net.ParseIP("fe80::1"))))
+}
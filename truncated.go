@@ -0,0 +1,52 @@
+package dnssd
+
+import (
+	"time"
+)
+
+// A query with the TC bit set waiting for the rest of its known answers,
+// RFC6762 section 7.2.
+type truncatedQuery struct {
+	im *incomingMsg
+	at time.Time
+}
+
+// Collect the known answers of a multi-packet query. Returns true if
+// the message was held back, to be answered when all known answers
+// should have arrived.
+func (ds *dnssd) collectKnownAnswers(im *incomingMsg) bool {
+	for _, tq := range ds.tqs {
+		if tq.im.ifIndex == im.ifIndex && matchAddr(tq.im.from, im.from) && len(im.msg.Question) == 0 {
+			qlog.Debug.Println("Known answers from", im.from, "=", len(im.msg.Answer))
+			tq.im.msg.Answer = append(tq.im.msg.Answer, im.msg.Answer...)
+			return true
+		}
+	}
+	if im.msg.Truncated {
+		at := time.Now().Add(400*time.Millisecond + randomDuration(100*time.Millisecond, 100))
+		qlog.Debug.Println("Truncated query from", im.from, ", answering at", at)
+		ds.tqs = append(ds.tqs, &truncatedQuery{im, at})
+		ds.nextCheckAt(at.Sub(time.Now()))
+		return true
+	}
+	return false
+}
+
+// Answer truncated queries that have waited long enough for their known
+// answers. Returns the time the next one should be answered.
+func (ds *dnssd) answerTruncatedQueries() time.Time {
+	now := time.Now()
+	var next time.Time
+	jj := 0
+	for _, tq := range ds.tqs {
+		if tq.at.After(now) {
+			next = getNextTime(next, tq.at)
+			ds.tqs[jj] = tq
+			jj++
+		} else {
+			ds.handleQuery(tq.im)
+		}
+	}
+	ds.tqs = ds.tqs[0:jj]
+	return next
+}
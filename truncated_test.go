@@ -0,0 +1,37 @@
+package dnssd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncatedQuery(t *testing.T) {
+	ds, _ := makeTestDnssd(t)
+	name := "_tuting._tcp"
+	ds.addPublishedAnswer(name, 2)
+	a := makeTestPtrAnswer(2, name, "tjohej", 12000)
+	ds.rrl.add(a)
+
+	im := fakeIncomingMsg(false)
+	im.msg.Question = []dns.Question{*makeTestPtrQuestion(name).q}
+	im.msg.Truncated = true
+	ds.handleIncomingMessage(im)
+	assert.Equal(t, 1, len(ds.tqs))
+	assert.Equal(t, 0, len(ds.ns.responses))
+
+	// The follow-up packet has the known answer.
+	fu := fakeIncomingMsg(false).addRR(name, dns.TypePTR, "hoppla").ttl(12000)
+	ds.handleIncomingMessage(fu)
+	assert.Equal(t, 1, len(ds.tqs))
+	assert.Equal(t, 0, len(ds.ns.responses))
+
+	assert.False(t, ds.answerTruncatedQueries().IsZero())
+	ds.tqs[0].at = time.Now()
+	assert.True(t, ds.answerTruncatedQueries().IsZero())
+	assert.Equal(t, 0, len(ds.tqs))
+	assert.Equal(t, 1, len(ds.ns.responseRecords()))
+	assert.Equal(t, "tjohej", ds.ns.responseRecords()[0].(*dns.PTR).Ptr)
+}
@@ -3,6 +3,8 @@ package dnssd
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -65,7 +67,7 @@ func getNextTime(t1, t2 time.Time) time.Time {
 	if t2.IsZero() {
 		return t1
 	}
-	if t1.After(t2) {
+	if t1.Before(t2) {
 		return t1
 	}
 	return t2
@@ -81,7 +83,11 @@ rrclass must match the question qclass unless the qclass is "ANY"
 is used, but should client software use classes other than 1, the
 matching rules described above MUST be used.
 */
+// An NSEC record answers the questions for the types it doesn't list.
 func matchQuestionAndRR(q *dns.Question, rr dns.RR) bool {
+	if isNegativeAnswer(q, rr) {
+		return q.Qclass == rr.Header().Class && q.Name == rr.Header().Name
+	}
 	return (q.Qtype == dns.TypeANY || q.Qtype == rr.Header().Rrtype || rr.Header().Rrtype == dns.TypeCNAME) &&
 		(q.Qclass == rr.Header().Class) &&
 		(q.Name == rr.Header().Name)
@@ -97,6 +103,25 @@ func matchRRs(rr1, rr2 dns.RR) bool {
 	return rr1.String() == rr2.String()
 }
 
+// Compare the name, type, class and rdata of two records ignoring the TTL.
+// Two records with the same rdata are not in conflict with each other.
+func matchRRData(rr1, rr2 dns.RR) bool {
+	c1 := dns.Copy(rr1)
+	c2 := dns.Copy(rr2)
+	c1.Header().Ttl = 0
+	c2.Header().Ttl = 0
+	return c1.String() == c2.String()
+}
+
+// Compare the rdata of two records ignoring the TTL and the cache-flush bit.
+func matchRRDataIgnoreFlush(rr1, rr2 dns.RR) bool {
+	c1 := dns.Copy(rr1)
+	c2 := dns.Copy(rr2)
+	c1.Header().Class &^= cacheFlushBit
+	c2.Header().Class &^= cacheFlushBit
+	return matchRRData(c1, c2)
+}
+
 func matchQuestions(q1, q2 *dns.Question) bool {
 	return (q1.Qtype == q2.Qtype) &&
 		(q1.Qclass == q2.Qclass) &&
@@ -112,3 +137,46 @@ func randomDuration(d time.Duration, variation int) time.Duration {
 	return time.Duration(vd)
 
 }
+
+// Create an alternative name for a name in conflict by adding, or
+// incrementing, a numeric suffix on the first label, e.g.
+// "tuting.local." becomes "tuting-2.local." and "tuting-2.local."
+// becomes "tuting-3.local."
+func alternativeName(name string) string {
+	label, rest := splitFirstLabel(name)
+	base, num := label, 1
+	if ii := strings.LastIndex(label, "-"); ii > 0 {
+		if n, err := strconv.Atoi(label[ii+1:]); err == nil && n > 0 {
+			base, num = label[:ii], n
+		}
+	}
+	return fmt.Sprint(base, "-", num+1, rest)
+}
+
+// Create an alternative service instance name for a name in conflict,
+// e.g. "Printer" becomes "Printer (2)" and "Printer (2)" becomes "Printer (3)"
+func alternativeServiceName(name string) string {
+	base, num := name, 1
+	if strings.HasSuffix(name, ")") {
+		if ii := strings.LastIndex(name, " ("); ii > 0 {
+			if n, err := strconv.Atoi(name[ii+2 : len(name)-1]); err == nil && n > 0 {
+				base, num = name[:ii], n
+			}
+		}
+	}
+	return fmt.Sprint(base, " (", num+1, ")")
+}
+
+// Split a domain name into the first label and the remainder of the name
+// including the leading dot. Escaped dots are part of the label.
+func splitFirstLabel(name string) (label, rest string) {
+	for ii := 0; ii < len(name); ii++ {
+		switch name[ii] {
+		case '\\':
+			ii++
+		case '.':
+			return name[:ii], name[ii:]
+		}
+	}
+	return name, ""
+}
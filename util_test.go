@@ -48,6 +48,28 @@ func TestMatchQuestions(t *testing.T) {
 	assert.True(t, matchQuestions(q1, q2))
 }
 
+func TestMatchRRData(t *testing.T) {
+	a1 := makeTestPtrAnswer(2, "hi_there", "wazzup", 3200)
+	a2 := makeTestPtrAnswer(2, "hi_there", "wazzup", 120)
+	a3 := makeTestPtrAnswer(2, "hi_there", "yowza", 3200)
+	assert.True(t, matchRRData(a1.rr, a2.rr))
+	assert.False(t, matchRRData(a1.rr, a3.rr))
+	assert.Equal(t, uint32(120), a2.rr.Header().Ttl)
+}
+
+func TestAlternativeName(t *testing.T) {
+	assert.Equal(t, "tuting-2.local.", alternativeName("tuting.local."))
+	assert.Equal(t, "tuting-3.local.", alternativeName("tuting-2.local."))
+	assert.Equal(t, "my-host-2.local.", alternativeName("my-host.local."))
+	assert.Equal(t, "a\\.b-2._tuting._tcp.local.", alternativeName("a\\.b._tuting._tcp.local."))
+}
+
+func TestAlternativeServiceName(t *testing.T) {
+	assert.Equal(t, "Printer (2)", alternativeServiceName("Printer"))
+	assert.Equal(t, "Printer (3)", alternativeServiceName("Printer (2)"))
+	assert.Equal(t, "Printer (Lab) (2)", alternativeServiceName("Printer (Lab)"))
+}
+
 func TestTimes(t *testing.T) {
 	var t1, t2 time.Time
 	t1 = time.Now()
@@ -57,4 +79,8 @@ func TestTimes(t *testing.T) {
 
 	tr = getNextTime(t2, t1)
 	assert.Equal(t, t1, tr)
+
+	t3 := t1.Add(time.Second)
+	assert.Equal(t, t1, getNextTime(t1, t3))
+	assert.Equal(t, t1, getNextTime(t3, t1))
 }